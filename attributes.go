@@ -13,6 +13,27 @@ const (
 	AccountingOff = 8
 )
 
+// Error-Cause values (RFC 5176 §3.5)
+const (
+	ResidualSessionContextRemoved       = 201
+	InvalidEAPPacket                    = 202
+	UnsupportedAttribute                = 401
+	MissingAttribute                    = 402
+	NASIdentificationMismatch           = 403
+	InvalidRequest                      = 404
+	UnsupportedService                  = 405
+	UnsupportedExtension                = 406
+	InvalidAttributeValue               = 407
+	AdministrativelyProhibited          = 501
+	RequestNotRoutable                  = 502
+	SessionContextNotFound              = 503
+	SessionContextNotRemovable          = 504
+	OtherProxyProcessingError           = 505
+	ResourcesUnavailable                = 506
+	RequestInitiated                    = 507
+	MultipleSessionSelectionUnsupported = 508
+)
+
 var (
 	ZeroedAuthenticator = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 )
@@ -26,6 +47,12 @@ var (
 	AccessChallenge    = uint8(11)
 	StatusServer       = uint8(12)
 	StatusClient       = uint8(13)
+	DisconnectRequest  = uint8(40)
+	DisconnectACK      = uint8(41)
+	DisconnectNAK      = uint8(42)
+	CoARequest         = uint8(43)
+	CoAACK             = uint8(44)
+	CoANAK             = uint8(45)
 
 	UserName               = uint8(1)
 	UserPassword           = uint8(2)
@@ -80,6 +107,9 @@ var (
 	NASPortType            = uint8(61)
 	PortLimit              = uint8(62)
 	LoginLATPort           = uint8(63)
+	EAPMessage             = uint8(79)
+	MessageAuthenticator   = uint8(80)
+	ErrorCause             = uint8(101)
 
 	request_type_to_string = map[uint8]string{
 		1:  "AccessRequest",
@@ -90,62 +120,70 @@ var (
 		11: "AccessChallenge",
 		12: "StatusServer",
 		13: "StatusClient",
+		40: "DisconnectRequest",
+		41: "DisconnectACK",
+		42: "DisconnectNAK",
+		43: "CoARequest",
+		44: "CoAACK",
+		45: "CoANAK",
 	}
 
 	code_to_attributes = map[uint8]string{
-		1:  "User-Name",
-		2:  "User-Password",
-		3:  "CHAP-Password",
-		4:  "NAS-IP-Address",
-		5:  "NAS-Port",
-		6:  "Service-Type",
-		7:  "Framed-Protocol",
-		8:  "Framed-IP-Address",
-		9:  "Framed-IP-Netmask",
-		10: "Framed-Routing",
-		11: "Filter-Id",
-		12: "Framed-MTU",
-		13: "Framed-Compression",
-		14: "Login-IP-Host",
-		15: "Login-Service",
-		16: "Login-TCP-Port",
-		18: "Reply-Message",
-		19: "Callback-Number",
-		20: "Callback-Id",
-		22: "Framed-Route",
-		23: "Framed-IPX-Network",
-		24: "State",
-		25: "Class",
-		26: "Vendor-Specific",
-		27: "Session-Timeout",
-		28: "Idle-Timeout",
-		29: "Termination-Action",
-		30: "Called-Station-Id",
-		31: "Calling-Station-Id",
-		32: "NAS-Identifier",
-		33: "Proxy-State",
-		34: "Login-LAT-Service",
-		35: "Login-LAT-Node",
-		36: "Login-LAT-Group",
-		37: "Framed-AppleTalk-Link",
-		38: "Framed-AppleTalk-Network",
-		39: "Framed-AppleTalk-Zone",
-		40: "Acct-Status-Type",
-		41: "Acct-Delay-Time",
-		42: "Acct-Input-Octets",
-		43: "Acct-Output-Octets",
-		44: "Acct-Session-Id",
-		45: "Acct-Authentic",
-		46: "Acct-Session-Time",
-		47: "Acct-Input-Packets",
-		48: "Acct-Output-Packets",
-		49: "Acct-Terminate-Cause",
-		50: "Acct-Multi-Session-Id",
-		51: "Acct-Link-Count",
-		60: "CHAP-Challenge",
-		61: "NAS-Port-Type",
-		62: "Port-Limit",
-		63: "Login-LAT-Port",
+		1:   "User-Name",
+		2:   "User-Password",
+		3:   "CHAP-Password",
+		4:   "NAS-IP-Address",
+		5:   "NAS-Port",
+		6:   "Service-Type",
+		7:   "Framed-Protocol",
+		8:   "Framed-IP-Address",
+		9:   "Framed-IP-Netmask",
+		10:  "Framed-Routing",
+		11:  "Filter-Id",
+		12:  "Framed-MTU",
+		13:  "Framed-Compression",
+		14:  "Login-IP-Host",
+		15:  "Login-Service",
+		16:  "Login-TCP-Port",
+		18:  "Reply-Message",
+		19:  "Callback-Number",
+		20:  "Callback-Id",
+		22:  "Framed-Route",
+		23:  "Framed-IPX-Network",
+		24:  "State",
+		25:  "Class",
+		26:  "Vendor-Specific",
+		27:  "Session-Timeout",
+		28:  "Idle-Timeout",
+		29:  "Termination-Action",
+		30:  "Called-Station-Id",
+		31:  "Calling-Station-Id",
+		32:  "NAS-Identifier",
+		33:  "Proxy-State",
+		34:  "Login-LAT-Service",
+		35:  "Login-LAT-Node",
+		36:  "Login-LAT-Group",
+		37:  "Framed-AppleTalk-Link",
+		38:  "Framed-AppleTalk-Network",
+		39:  "Framed-AppleTalk-Zone",
+		40:  "Acct-Status-Type",
+		41:  "Acct-Delay-Time",
+		42:  "Acct-Input-Octets",
+		43:  "Acct-Output-Octets",
+		44:  "Acct-Session-Id",
+		45:  "Acct-Authentic",
+		46:  "Acct-Session-Time",
+		47:  "Acct-Input-Packets",
+		48:  "Acct-Output-Packets",
+		49:  "Acct-Terminate-Cause",
+		50:  "Acct-Multi-Session-Id",
+		51:  "Acct-Link-Count",
+		60:  "CHAP-Challenge",
+		61:  "NAS-Port-Type",
+		62:  "Port-Limit",
+		63:  "Login-LAT-Port",
+		80:  "Message-Authenticator",
+		101: "Error-Cause",
 	}
 
 	attributes_to_code = map[string]uint8{
@@ -202,5 +240,7 @@ var (
 		"NAS-Port-Type":            61,
 		"Port-Limit":               62,
 		"Login-LAT-Port":           63,
+		"Message-Authenticator":    80,
+		"Error-Cause":              101,
 	}
 )
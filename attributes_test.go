@@ -0,0 +1,21 @@
+package goradius
+
+import "testing"
+
+func TestStandardNamesFromDefaultDictionary(t *testing.T) {
+
+	for _, d := range []*Dictionary{DefaultDictionary, NewEmptyDictionary()} {
+
+		for name, code := range map[string]uint8{"User-Name": 1, "Tunnel-Password": 69, "EAP-Message": 79, "NAS-Port-Id": 87} {
+			got, ok := d.attributeCode(name)
+			if !ok || got != code {
+				t.Errorf("attributeCode(%q) = %v, %v, want %v", name, got, ok, code)
+			}
+		}
+
+		attr := RadiusAttribute{Type: 87, Value: []byte("eth0")}
+		if got := d.FormatAttribute(attr); got != `NAS-Port-Id: "eth0"` {
+			t.Errorf("FormatAttribute = %q", got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package goradius
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultChallengeTimeout is how long the data given to ChallengeWith waits
+// for the Access-Request answering the challenge.
+const DefaultChallengeTimeout = 2 * time.Minute
+
+type challengeContextKey int
+
+const challengeDataContextKey challengeContextKey = 0
+
+// ChallengeFromContext returns the data given to ChallengeWith when the
+// request ctx belongs to answers that Access-Challenge. ok is false for
+// requests without a State, or with one that is unknown, expired or was
+// sent to another NAS.
+func ChallengeFromContext(ctx context.Context) (interface{}, bool) {
+
+	c, ok := ctx.Value(challengeDataContextKey).(*challengeData)
+	if !ok {
+		return nil, false
+	}
+
+	return c.value, true
+}
+
+// challengeData boxes the stored value, which may itself be nil, with the
+// NAS the challenge was sent to.
+type challengeData struct {
+	value interface{}
+	owner string
+}
+
+// challengeOwner identifies the NAS of req by its address, registered
+// clients or not.
+func challengeOwner(req *RadiusPacket) string {
+
+	if req == nil || req.Addr == nil {
+		return ""
+	}
+
+	return req.Addr.IP.String()
+}
+
+func (r *RadiusServer) challengeTimeout() time.Duration {
+
+	if r.ChallengeTimeout == 0 {
+		return DefaultChallengeTimeout
+	}
+
+	return r.ChallengeTimeout
+}
+
+// resumeChallenge adds to ctx the data stored by the Access-Challenge req
+// answers. A State is only good for one request, the next round gets a new
+// one, and only from the NAS it was sent to.
+func (r *RadiusServer) resumeChallenge(ctx context.Context, req *RadiusPacket) context.Context {
+
+	if req.Code != AccessRequest {
+		return ctx
+	}
+
+	state := req.GetAttributeByID(standardAttribute(State))
+	if len(state) == 0 {
+		return ctx
+	}
+
+	// another NAS must not use up the State either
+	value, ok := r.challenges.get(state[0])
+	if !ok || value.(*challengeData).owner != challengeOwner(req) {
+		return ctx
+	}
+
+	value, ok = r.challenges.take(state[0])
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, challengeDataContextKey, value)
+}
+
+func (w *responseWriter) ChallengeWith(prompt string, data interface{}) {
+
+	res := w.response
+	res.Code = AccessChallenge
+
+	res.RemoveAttributeByID(standardAttribute(ReplyMessage))
+	for msg := []byte(prompt); len(msg) > 0; {
+		chunk := msg
+		if len(chunk) > maxAttributeValue {
+			chunk = chunk[:maxAttributeValue]
+		}
+		msg = msg[len(chunk):]
+
+		res.AddAttributeByType(ReplyMessage, chunk)
+	}
+
+	res.RemoveAttributeByID(standardAttribute(State))
+	res.AddAttributeByType(State, w.server.challenges.add(&challengeData{data, challengeOwner(w.request)}, w.server.challengeTimeout()))
+}
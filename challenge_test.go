@@ -0,0 +1,126 @@
+package goradius
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startChallengeServer serves a zero value RadiusServer, without
+// NewRadiusServer, that challenges new requests and accepts the answers.
+func startChallengeServer(t *testing.T, timeout time.Duration) (*RadiusServer, func(state []byte) *RadiusPacket) {
+
+	t.Helper()
+
+	r := &RadiusServer{Secret: "secret", ChallengeTimeout: timeout}
+	r.HandleFunc(AccessRequest, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		data, ok := ChallengeFromContext(ctx)
+		if !ok {
+			w.ChallengeWith("Enter the code", "steve")
+			return nil
+		}
+		if data == "steve" {
+			w.Response().Code = AccessAccept
+		} else {
+			w.Response().Code = AccessReject
+		}
+		return nil
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve(conn)
+
+	client := NewClient("secret")
+	client.Timeout = time.Second
+
+	exchange := func(state []byte) *RadiusPacket {
+		t.Helper()
+		req := NewRadiusPacket()
+		req.Code = AccessRequest
+		req.AddAttribute("User-Name", []byte("steve"))
+		if state != nil {
+			req.AddAttributeByType(State, state)
+		}
+		res, err := client.Exchange(context.Background(), req, conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	return r, exchange
+}
+
+func TestChallengeWith(t *testing.T) {
+
+	r, exchange := startChallengeServer(t, 0)
+	defer r.Close()
+
+	res := exchange(nil)
+	state := res.GetFirstAttribute("State")
+	if res.Code != AccessChallenge || len(state) != stateLength {
+		t.Fatalf("got code %v with State %x, want an Access-Challenge", res.Code, state)
+	}
+	if prompt := res.GetFirstAttribute("Reply-Message"); string(prompt) != "Enter the code" {
+		t.Errorf("got Reply-Message %q", prompt)
+	}
+
+	if res := exchange(state); res.Code != AccessAccept {
+		t.Errorf("answer to the challenge got code %v, want Access-Accept", res.Code)
+	}
+
+	// a State is only good once
+	res = exchange(state)
+	if res.Code != AccessChallenge {
+		t.Errorf("replayed State got code %v, want a new Access-Challenge", res.Code)
+	}
+	if bytes.Equal(res.GetFirstAttribute("State"), state) {
+		t.Errorf("State reused")
+	}
+}
+
+func TestChallengeExpired(t *testing.T) {
+
+	r, exchange := startChallengeServer(t, 50*time.Millisecond)
+	defer r.Close()
+
+	state := exchange(nil).GetFirstAttribute("State")
+	time.Sleep(100 * time.Millisecond)
+	if res := exchange(state); res.Code != AccessChallenge {
+		t.Errorf("expired State got code %v, want a new Access-Challenge", res.Code)
+	}
+}
+
+func TestChallengeOtherNAS(t *testing.T) {
+
+	r := &RadiusServer{}
+
+	request := func(ip string, state []byte) *RadiusPacket {
+		req := NewRadiusPacket()
+		req.Code = AccessRequest
+		req.Addr = &net.UDPAddr{IP: net.ParseIP(ip), Port: 1812}
+		if state != nil {
+			req.AddAttributeByType(State, state)
+		}
+		return req
+	}
+
+	w := &responseWriter{request: request("192.0.2.1", nil), response: NewRadiusPacket(), server: r}
+	w.ChallengeWith("Enter the code", "steve")
+	state := w.Response().GetFirstAttribute("State")
+
+	ctx := r.resumeChallenge(context.Background(), request("192.0.2.2", state))
+	if _, ok := ChallengeFromContext(ctx); ok {
+		t.Errorf("another NAS resumed the challenge")
+	}
+
+	ctx = r.resumeChallenge(context.Background(), request("192.0.2.1", state))
+	if data, ok := ChallengeFromContext(ctx); !ok || data != "steve" {
+		t.Errorf("got %v %v, want the data of the challenge", data, ok)
+	}
+}
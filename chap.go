@@ -0,0 +1,80 @@
+package goradius
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrUnknownUser is returned, possibly wrapped, by a PasswordSource that
+// doesn't know the user, the request is rejected.
+var ErrUnknownUser = errors.New("Unknown user.")
+
+// PasswordSource gives the clear text password of users, which challenge
+// based methods such as CHAP need to check the credentials.
+type PasswordSource interface {
+	Password(ctx context.Context, username string) ([]byte, error)
+}
+
+// PasswordSourceFunc lets an ordinary function be used as a PasswordSource.
+type PasswordSourceFunc func(ctx context.Context, username string) ([]byte, error)
+
+func (f PasswordSourceFunc) Password(ctx context.Context, username string) ([]byte, error) {
+	return f(ctx, username)
+}
+
+const chapPasswordLength = 17
+
+// VerifyCHAP reports whether the CHAP-Password of req was calculated with
+// password, MD5(CHAP Ident + password + challenge) as in RFC 1994. The
+// challenge is the CHAP-Challenge, or the Request Authenticator when there
+// is none (RFC 2865 §2.2).
+func VerifyCHAP(req *RadiusPacket, password []byte) bool {
+
+	chap := req.GetFirstAttribute("CHAP-Password")
+	if len(chap) != chapPasswordLength {
+		return false
+	}
+
+	challenge := req.GetFirstAttribute("CHAP-Challenge")
+	if challenge == nil {
+		challenge = req.Authenticator[:]
+	}
+
+	hash := md5.New()
+	hash.Write(chap[:1])
+	hash.Write(password)
+	hash.Write(challenge)
+
+	return subtle.ConstantTimeCompare(hash.Sum(nil), chap[1:]) == 1
+}
+
+// CHAPAuth checks the Access-Requests carrying a CHAP-Password against the
+// passwords of source. Users with valid credentials are accepted and the
+// request goes on to next, which can still reject it or add attributes.
+// Others are rejected right away. Requests without CHAP-Password go to next
+// untouched, and errors of source other than ErrUnknownUser drop the request.
+func CHAPAuth(source PasswordSource) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+
+			if req.Code != AccessRequest || req.GetFirstAttribute("CHAP-Password") == nil {
+				return next.ServeRADIUS(ctx, w, req)
+			}
+
+			password, err := source.Password(ctx, req.GetFirstAttributeAsString("User-Name"))
+			if err != nil && !errors.Is(err, ErrUnknownUser) {
+				return err
+			}
+
+			if errors.Is(err, ErrUnknownUser) || !VerifyCHAP(req, password) {
+				w.Response().Code = AccessReject
+				return nil
+			}
+
+			w.Response().Code = AccessAccept
+			return next.ServeRADIUS(ctx, w, req)
+		})
+	}
+}
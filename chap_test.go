@@ -0,0 +1,113 @@
+package goradius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// chapRequest is an Access-Request with the authenticator of RFC 2865 §7.1
+// and a CHAP-Password of ident and response, for the password "arctangent".
+func chapRequest(username string, ident byte, response string, challenge []byte) *RadiusPacket {
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.Authenticator = rfc2865Authenticator()
+	req.AddAttribute("User-Name", []byte(username))
+	req.AddAttribute("CHAP-Password", append([]byte{ident}, unhex(response)...))
+	if challenge != nil {
+		req.AddAttribute("CHAP-Challenge", challenge)
+	}
+
+	return req
+}
+
+// MD5(CHAP Ident + "arctangent" + challenge), RFC 1994 §4.1
+const (
+	chapResponseAuthenticator = "f2f5768de3e4965f168061610d4a809c"
+	chapResponseChallenge     = "4a01f28d05f68a7f5a91153f08181f23"
+)
+
+var chapChallenge = unhex("9a5c2b1fd4a1c35f07e8d5c60a2b71e3")
+
+func TestVerifyCHAP(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		req      *RadiusPacket
+		password string
+		ok       bool
+	}{
+		{"Request Authenticator", chapRequest("nemo", 0x17, chapResponseAuthenticator, nil), "arctangent", true},
+		{"CHAP-Challenge", chapRequest("nemo", 0x2a, chapResponseChallenge, chapChallenge), "arctangent", true},
+		{"wrong password", chapRequest("nemo", 0x17, chapResponseAuthenticator, nil), "arctangent!", false},
+		{"wrong ident", chapRequest("nemo", 0x18, chapResponseAuthenticator, nil), "arctangent", false},
+		{"challenge ignored", chapRequest("nemo", 0x17, chapResponseAuthenticator, chapChallenge), "arctangent", false},
+		{"short CHAP-Password", chapRequest("nemo", 0x17, chapResponseAuthenticator[:30], nil), "arctangent", false},
+		{"long CHAP-Password", chapRequest("nemo", 0x17, chapResponseAuthenticator+"00", nil), "arctangent", false},
+	}
+
+	for _, tt := range tests {
+		if ok := VerifyCHAP(tt.req, []byte(tt.password)); ok != tt.ok {
+			t.Errorf("%v: got %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestCHAPAuth(t *testing.T) {
+
+	errDirectory := errors.New("directory down")
+	source := PasswordSourceFunc(func(ctx context.Context, username string) ([]byte, error) {
+		switch username {
+		case "nemo":
+			return []byte("arctangent"), nil
+		case "moved":
+			return nil, fmt.Errorf("directory lookup: %w", ErrUnknownUser)
+		case "broken":
+			return nil, errDirectory
+		}
+		return nil, ErrUnknownUser
+	})
+
+	reached := false
+	handler := CHAPAuth(source)(HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		reached = true
+		return nil
+	}))
+
+	tests := []struct {
+		name string
+		req  *RadiusPacket
+		code uint8
+		err  error
+	}{
+		{"valid", chapRequest("nemo", 0x2a, chapResponseChallenge, chapChallenge), AccessAccept, nil},
+		{"wrong response", chapRequest("nemo", 0x2a, chapResponseAuthenticator, chapChallenge), AccessReject, nil},
+		{"wrong length", chapRequest("nemo", 0x2a, chapResponseChallenge[:30], chapChallenge), AccessReject, nil},
+		{"unknown user", chapRequest("nobody", 0x2a, chapResponseChallenge, chapChallenge), AccessReject, nil},
+		{"wrapped unknown user", chapRequest("moved", 0x2a, chapResponseChallenge, chapChallenge), AccessReject, nil},
+		{"source error", chapRequest("broken", 0x2a, chapResponseChallenge, chapChallenge), 0, errDirectory},
+	}
+
+	for _, tt := range tests {
+
+		reached = false
+		w := &responseWriter{response: NewRadiusPacket()}
+		err := handler.ServeRADIUS(context.Background(), w, tt.req)
+		if err != tt.err {
+			t.Errorf("%v: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if tt.err != nil {
+			continue
+		}
+
+		if code := w.Response().Code; code != tt.code {
+			t.Errorf("%v: got code %v, want %v", tt.name, code, tt.code)
+		}
+		if reached != (tt.code == AccessAccept) {
+			t.Errorf("%v: next reached %v", tt.name, reached)
+		}
+	}
+}
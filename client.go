@@ -0,0 +1,410 @@
+package goradius
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultClientTimeout    = 2 * time.Second
+	DefaultClientMaxTimeout = 16 * time.Second
+	DefaultClientRetries    = 3
+)
+
+var (
+	ErrNoIdentifiers = errors.New("No free Identifier for destination.")
+	ErrNoResponse    = errors.New("No response from server.")
+)
+
+// Client sends requests to a RADIUS server and waits for the reply,
+// retransmitting when none arrives in time.
+type Client struct {
+	Secret string
+
+	// Net is the transport used: "udp" (the default), "tcp" or "tls".
+	Net       string
+	TLSConfig *tls.Config
+
+	// Timeout is how long the first attempt waits for a reply. Every
+	// retransmission waits twice as long as the previous one, up to
+	// MaxTimeout, unless Backoff is set.
+	Timeout    time.Duration
+	MaxTimeout time.Duration
+	Retries    int
+	Backoff    func(attempt int) time.Duration
+
+	// Dictionary decodes the replies and encodes requests that don't have
+	// their own, DefaultDictionary when nil.
+	Dictionary *Dictionary
+
+	// RequireMessageAuthenticator makes replies without a
+	// Message-Authenticator invalid.
+	RequireMessageAuthenticator bool
+
+	lock        sync.Mutex
+	identifiers map[string]*identifierPool
+}
+
+// NewTLSClient returns a RadSec client, config holds the client
+// certificate and the CAs the server certificate is checked against.
+func NewTLSClient(config *tls.Config) *Client {
+
+	c := NewClient(RadSecSecret)
+	c.Net = "tls"
+	c.TLSConfig = config
+
+	return c
+}
+
+func NewClient(secret string) *Client {
+
+	c := Client{}
+	c.Secret = secret
+	c.Timeout = DefaultClientTimeout
+	c.MaxTimeout = DefaultClientMaxTimeout
+	c.Retries = DefaultClientRetries
+
+	return &c
+}
+
+// identifierPool hands out the Identifiers in use towards a destination.
+type identifierPool struct {
+	next  uint8
+	inUse int
+	used  [256]bool
+}
+
+func (c *Client) acquireIdentifier(dest string) (uint8, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.identifiers == nil {
+		c.identifiers = make(map[string]*identifierPool)
+	}
+
+	pool, ok := c.identifiers[dest]
+	if !ok {
+		pool = &identifierPool{}
+		c.identifiers[dest] = pool
+	}
+
+	if pool.inUse == len(pool.used) {
+		return 0, ErrNoIdentifiers
+	}
+
+	for pool.used[pool.next] {
+		pool.next++
+	}
+
+	id := pool.next
+	pool.used[id] = true
+	pool.inUse++
+	pool.next++
+
+	return id, nil
+}
+
+func (c *Client) releaseIdentifier(dest string, id uint8) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pool := c.identifiers[dest]
+	pool.used[id] = false
+	pool.inUse--
+
+	if pool.inUse == 0 {
+		delete(c.identifiers, dest)
+	}
+}
+
+// attemptTimeout returns how long attempt (0 based) waits for a reply.
+func (c *Client) attemptTimeout(attempt int) time.Duration {
+
+	if c.Backoff != nil {
+		return c.Backoff(attempt)
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultClientTimeout
+	}
+
+	for i := 0; i < attempt; i++ {
+		timeout *= 2
+		if c.MaxTimeout > 0 && timeout >= c.MaxTimeout {
+			return c.MaxTimeout
+		}
+	}
+
+	return timeout
+}
+
+// Exchange sends packet to addr and returns the validated reply. The
+// Identifier is allocated by the client and Access-Request and Status-Server
+// packets get a new random Request Authenticator and a Message-Authenticator.
+func (c *Client) Exchange(ctx context.Context, packet *RadiusPacket, addr string) (*RadiusPacket, error) {
+
+	id, err := c.acquireIdentifier(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseIdentifier(addr, id)
+
+	request, err := c.prepare(packet, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Net {
+	case "", "udp":
+		return c.exchangeUDP(ctx, request, addr)
+	case "tcp", "tls":
+		return c.exchangeStream(ctx, request, addr)
+	}
+
+	return nil, errors.New("Unknown network: " + c.Net)
+}
+
+func (c *Client) exchangeUDP(ctx context.Context, request []byte, addr string) (*RadiusPacket, error) {
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := unblockOnDone(ctx, conn)
+	defer close(stop)
+
+	bufr := make([]byte, maxPacketLength)
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+
+		if err := contextError(ctx); err != nil {
+			return nil, err
+		}
+
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(c.attemptTimeout(attempt))
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(bufr)
+			if err != nil {
+				if err := contextError(ctx); err != nil {
+					return nil, err
+				}
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			response, ok := c.validate(request, bufr[:n])
+			if ok {
+				response.Addr = raddr
+				return response, nil
+			}
+		}
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrNoResponse
+}
+
+// exchangeStream sends request over a new TCP or TLS connection. Streams are
+// reliable so nothing is retransmitted (RFC 6613 §2.6), the client waits as
+// long as all the UDP attempts together would.
+func (c *Client) exchangeStream(ctx context.Context, request []byte, addr string) (*RadiusPacket, error) {
+
+	var wait time.Duration
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		wait += c.attemptTimeout(attempt)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+
+	var conn net.Conn
+	var err error
+	if c.Net == "tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: c.TLSConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		if cerr := contextError(ctx); cerr != nil {
+			return nil, ErrNoResponse
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := unblockOnDone(ctx, conn)
+	defer close(stop)
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	for {
+
+		header := make([]byte, headerEnd)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if contextError(ctx) != nil {
+				return nil, ErrNoResponse
+			}
+			return nil, err
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if length < headerEnd || length > maxPacketLength {
+			return nil, errors.New("Invalid packet length in stream.")
+		}
+
+		rawMsg := make([]byte, length)
+		copy(rawMsg, header)
+		if _, err := io.ReadFull(conn, rawMsg[headerEnd:]); err != nil {
+			return nil, err
+		}
+
+		response, ok := c.validate(request, rawMsg)
+		if ok {
+			response.Addr = udpAddr(conn.RemoteAddr())
+			return response, nil
+		}
+	}
+}
+
+// unblockOnDone makes reads on conn fail once ctx is done, until the
+// returned channel is closed.
+func unblockOnDone(ctx context.Context, conn net.Conn) chan struct{} {
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return stop
+}
+
+func (c *Client) dictionary() *Dictionary {
+
+	if c.Dictionary == nil {
+		return DefaultDictionary
+	}
+
+	return c.Dictionary
+}
+
+// contextError is ctx.Err() but doesn't wait for the context's timer to
+// notice that the deadline has passed.
+func contextError(ctx context.Context) error {
+
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+
+	return ctx.Err()
+}
+
+// prepare sets the Identifier and authenticators of packet and encodes it.
+func (c *Client) prepare(packet *RadiusPacket, id uint8) ([]byte, error) {
+
+	packet.Identifier = id
+
+	if packet.Dictionary == nil {
+		packet.Dictionary = c.Dictionary
+	}
+
+	if packet.Code == AccessRequest || packet.Code == StatusServer {
+		packet.Authenticator = GenerateRandomAuthenticator()
+		packet.addMessageAuthenticator()
+	}
+
+	return EncodeAndSign(packet, c.Secret)
+}
+
+// validate checks that rawMsg is a reply to request and parses it.
+func (c *Client) validate(request []byte, rawMsg []byte) (*RadiusPacket, bool) {
+
+	if len(rawMsg) < headerEnd || rawMsg[1] != request[1] {
+		return nil, false
+	}
+
+	if !isReplyTo(request[0], rawMsg[0]) {
+		return nil, false
+	}
+
+	length := int(rawMsg[2])<<8 | int(rawMsg[3])
+	if length < headerEnd || length > len(rawMsg) {
+		return nil, false
+	}
+	rawMsg = rawMsg[:length]
+
+	requestAuthenticator := request[4:headerEnd]
+	if !VerifyResponseAuthenticator(rawMsg, requestAuthenticator, c.Secret) {
+		return nil, false
+	}
+
+	present, valid := VerifyMessageAuthenticator(rawMsg, requestAuthenticator, c.Secret)
+	if (present && !valid) || (!present && c.RequireMessageAuthenticator) {
+		return nil, false
+	}
+
+	response, err := c.dictionary().ParseResponse(rawMsg, requestAuthenticator, c.Secret)
+	if err != nil {
+		return nil, false
+	}
+
+	return response, true
+}
+
+// isReplyTo reports whether a packet with code answers a request with
+// requestCode.
+func isReplyTo(requestCode, code uint8) bool {
+
+	switch requestCode {
+	case AccessRequest:
+		return code == AccessAccept || code == AccessReject || code == AccessChallenge
+	case AccountingRequest:
+		return code == AccountingResponse
+	case StatusServer:
+		return code == AccessAccept || code == AccountingResponse
+	case DisconnectRequest:
+		return code == DisconnectACK || code == DisconnectNAK
+	case CoARequest:
+		return code == CoAACK || code == CoANAK
+	}
+
+	return false
+}
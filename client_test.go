@@ -0,0 +1,270 @@
+package goradius
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the requests it gets with what reply returns for them,
+// attempt counting the requests received so far from 0.
+type fakeServer struct {
+	conn net.PacketConn
+
+	lock     sync.Mutex
+	requests [][]byte
+	times    []time.Time
+}
+
+func startFakeServer(t *testing.T, reply func(attempt int, request []byte) [][]byte) *fakeServer {
+
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{conn: conn}
+
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := append([]byte{}, buf[:n]...)
+
+			s.lock.Lock()
+			attempt := len(s.requests)
+			s.requests = append(s.requests, request)
+			s.times = append(s.times, time.Now())
+			s.lock.Unlock()
+
+			for _, raw := range reply(attempt, request) {
+				conn.WriteTo(raw, addr)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *fakeServer) received() ([][]byte, []time.Time) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.requests, s.times
+}
+
+// signedReply answers request with code and a Reply-Message, signed with
+// secret.
+func signedReply(t *testing.T, request []byte, code uint8, message string, secret string) []byte {
+
+	t.Helper()
+
+	res := NewRadiusPacket()
+	res.Code = code
+	res.Identifier = request[1]
+	copy(res.Authenticator[:], request[4:headerEnd])
+	res.AddAttribute("Reply-Message", []byte(message))
+	res.addMessageAuthenticator()
+
+	raw, err := EncodeAndSign(res, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func accessRequestPacket() *RadiusPacket {
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.AddAttribute("User-Name", []byte("steve"))
+
+	return req
+}
+
+func TestClientRetransmits(t *testing.T) {
+
+	s := startFakeServer(t, func(attempt int, request []byte) [][]byte {
+		if attempt < 2 {
+			return nil
+		}
+		return [][]byte{signedReply(t, request, AccessAccept, "good", "secret")}
+	})
+	defer s.conn.Close()
+
+	client := NewClient("secret")
+	client.Timeout = 50 * time.Millisecond
+	client.MaxTimeout = 80 * time.Millisecond
+
+	res, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != AccessAccept {
+		t.Errorf("got code %v", res.Code)
+	}
+
+	requests, times := s.received()
+	if len(requests) != 3 {
+		t.Fatalf("got %v requests, want 3", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		if !bytes.Equal(requests[i], requests[0]) {
+			t.Errorf("retransmission %v differs from the request", i)
+		}
+	}
+
+	// 50ms, then doubled but capped at 80ms
+	for i, want := range []time.Duration{50 * time.Millisecond, 80 * time.Millisecond} {
+		if gap := times[i+1].Sub(times[i]); gap < want {
+			t.Errorf("retransmission %v after %v, want at least %v", i+1, gap, want)
+		}
+	}
+}
+
+func TestClientNoResponse(t *testing.T) {
+
+	s := startFakeServer(t, func(attempt int, request []byte) [][]byte {
+		return nil
+	})
+	defer s.conn.Close()
+
+	client := NewClient("secret")
+	client.Retries = 2
+	client.Backoff = func(attempt int) time.Duration {
+		return 10 * time.Millisecond
+	}
+
+	if _, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr()); err != ErrNoResponse {
+		t.Errorf("got %v, want ErrNoResponse", err)
+	}
+	if requests, _ := s.received(); len(requests) != 3 {
+		t.Errorf("got %v requests, want 3", len(requests))
+	}
+}
+
+func TestClientAttemptTimeout(t *testing.T) {
+
+	client := NewClient("secret")
+	client.Timeout = time.Second
+	client.MaxTimeout = 5 * time.Second
+
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := client.attemptTimeout(attempt); got != want {
+			t.Errorf("attempt %v waits %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestClientOutOfIdentifiers(t *testing.T) {
+
+	s := startFakeServer(t, func(attempt int, request []byte) [][]byte {
+		return [][]byte{signedReply(t, request, AccessAccept, "good", "secret")}
+	})
+	defer s.conn.Close()
+
+	client := NewClient("secret")
+	client.Timeout = 200 * time.Millisecond
+
+	// requests still waiting for their reply hold every Identifier
+	seen := map[uint8]bool{}
+	for i := 0; i < 256; i++ {
+		id, err := client.acquireIdentifier(s.addr())
+		if err != nil {
+			t.Fatalf("Identifier %v: %v", i, err)
+		}
+		if seen[id] {
+			t.Fatalf("Identifier %v handed out twice", id)
+		}
+		seen[id] = true
+	}
+
+	if _, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr()); err != ErrNoIdentifiers {
+		t.Errorf("got %v, want ErrNoIdentifiers", err)
+	}
+	if _, err := client.acquireIdentifier("192.0.2.1:1812"); err != nil {
+		t.Errorf("other destination: %v", err)
+	}
+
+	client.releaseIdentifier(s.addr(), 42)
+	res, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Identifier != 42 {
+		t.Errorf("got Identifier %v, want the released 42", res.Identifier)
+	}
+}
+
+func TestClientRejectsBadReplies(t *testing.T) {
+
+	tests := []struct {
+		name string
+		bad  func(request []byte) []byte
+	}{
+		{"wrong Identifier", func(request []byte) []byte {
+			other := append([]byte{}, request...)
+			other[1]++
+			return signedReply(t, other, AccessAccept, "bad", "secret")
+		}},
+		{"wrong code", func(request []byte) []byte {
+			return signedReply(t, request, AccountingResponse, "bad", "secret")
+		}},
+		{"bad Response Authenticator", func(request []byte) []byte {
+			return signedReply(t, request, AccessAccept, "bad", "other")
+		}},
+		{"bad Message-Authenticator", func(request []byte) []byte {
+			raw := signedReply(t, request, AccessAccept, "bad", "secret")
+			raw[messageAuthenticatorOffset(raw)+2] ^= 1
+			// a valid Response Authenticator over the broken attribute
+			copy(raw[4:headerEnd], request[4:headerEnd])
+			CalculateResponseAuthenticator(raw, "secret")
+			return raw
+		}},
+		{"truncated", func(request []byte) []byte {
+			return signedReply(t, request, AccessAccept, "bad", "secret")[:headerEnd-1]
+		}},
+	}
+
+	for _, tt := range tests {
+
+		s := startFakeServer(t, func(attempt int, request []byte) [][]byte {
+			return [][]byte{tt.bad(request), signedReply(t, request, AccessAccept, "good", "secret")}
+		})
+
+		client := NewClient("secret")
+		client.Timeout = time.Second
+		res, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr())
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+		} else if msg := res.GetFirstAttributeAsString("Reply-Message"); msg != "good" {
+			t.Errorf("%v: got the %v reply", tt.name, msg)
+		}
+
+		// with nothing but the bad reply there is no answer
+		s.conn.Close()
+		s = startFakeServer(t, func(attempt int, request []byte) [][]byte {
+			return [][]byte{tt.bad(request)}
+		})
+
+		client.Timeout = 50 * time.Millisecond
+		client.Retries = 0
+		if _, err := client.Exchange(context.Background(), accessRequestPacket(), s.addr()); err != ErrNoResponse {
+			t.Errorf("%v alone: got %v, want ErrNoResponse", tt.name, err)
+		}
+		s.conn.Close()
+	}
+}
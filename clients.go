@@ -0,0 +1,195 @@
+package goradius
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// RadiusClient is a NAS that is allowed to talk to the server.
+type RadiusClient struct {
+	Name    string
+	Secret  string
+	NASType string
+	Network *net.IPNet
+
+	MessageAuthenticator MessageAuthenticatorPolicy
+}
+
+func (c RadiusClient) String() string {
+	return fmt.Sprintf("RadiusClient{%v %v %v}", c.Name, c.Network, c.NASType)
+}
+
+// ClientRegistry holds the NAS clients, matched by exact IP first and then
+// by the most specific CIDR range. The zero value is ready to use.
+type ClientRegistry struct {
+	lock      sync.RWMutex
+	exact     map[string]*RadiusClient
+	networks  []*RadiusClient
+	certNames map[string]*RadiusClient
+}
+
+func NewClientRegistry() *ClientRegistry {
+
+	c := ClientRegistry{}
+
+	return &c
+}
+
+// Add registers a client. addr can be a single IP ("10.0.0.1") or a CIDR
+// range ("10.0.0.0/24").
+func (c *ClientRegistry) Add(addr, name, secret, nasType string) (*RadiusClient, error) {
+
+	if secret == "" {
+		return nil, errors.New("Client secret can't be empty.")
+	}
+
+	network, err := parseClientAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &RadiusClient{
+		Name:    name,
+		Secret:  secret,
+		NASType: nasType,
+		Network: network,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ones, bits := network.Mask.Size()
+	if ones == bits {
+		if c.exact == nil {
+			c.exact = make(map[string]*RadiusClient)
+		}
+		c.exact[network.IP.String()] = client
+		return client, nil
+	}
+
+	for i, n := range c.networks {
+		if n.Network.String() == network.String() {
+			c.networks[i] = client
+			return client, nil
+		}
+	}
+
+	// keep the ranges sorted from the most to the least specific so the
+	// first match wins
+	pos := len(c.networks)
+	for i, n := range c.networks {
+		nOnes, _ := n.Network.Mask.Size()
+		if ones > nOnes {
+			pos = i
+			break
+		}
+	}
+
+	c.networks = append(c.networks, nil)
+	copy(c.networks[pos+1:], c.networks[pos:])
+	c.networks[pos] = client
+
+	return client, nil
+}
+
+// Remove deletes the client registered under addr, written in any form Add
+// accepts.
+func (c *ClientRegistry) Remove(addr string) {
+
+	network, err := parseClientAddr(addr)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ones, bits := network.Mask.Size()
+	if ones == bits {
+		delete(c.exact, network.IP.String())
+		return
+	}
+
+	for i, n := range c.networks {
+		if n.Network.String() == network.String() {
+			c.networks = append(c.networks[:i], c.networks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Find returns the client that ip belongs to.
+func (c *ClientRegistry) Find(ip net.IP) (*RadiusClient, bool) {
+
+	if ip == nil {
+		return nil, false
+	}
+
+	ip = normalizeIP(ip)
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if client, ok := c.exact[ip.String()]; ok {
+		return client, true
+	}
+
+	for _, n := range c.networks {
+		if n.Network.Contains(ip) {
+			return n, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *ClientRegistry) Len() int {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return len(c.exact) + len(c.networks)
+}
+
+// parseClientAddr returns the network of a single IP or a CIDR range, with
+// the host bits of the range cleared.
+func parseClientAddr(addr string) (*net.IPNet, error) {
+
+	if strings.Contains(addr, "/") {
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, err
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, errors.New("Invalid client address: " + addr)
+	}
+
+	return hostNetwork(ip), nil
+}
+
+func normalizeIP(ip net.IP) net.IP {
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+func hostNetwork(ip net.IP) *net.IPNet {
+
+	ip = normalizeIP(ip)
+	bits := len(ip) * 8
+
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}
+}
@@ -0,0 +1,73 @@
+package goradius
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientRegistryRemove(t *testing.T) {
+
+	tests := []struct {
+		add, remove string
+	}{
+		{"10.20.0.1/16", "10.20.0.1/16"},
+		{"10.20.0.0/16", "10.20.5.5/16"},
+		{"10.0.0.1", "10.0.0.1/32"},
+		{"10.0.0.1/32", "10.0.0.1"},
+		{"2001:db8::1/128", "2001:db8::1"},
+		{"2001:db8::/32", "2001:db8:0:0::/32"},
+	}
+
+	for _, test := range tests {
+		c := NewClientRegistry()
+		if _, err := c.Add(test.add, "nas", "secret", ""); err != nil {
+			t.Fatalf("Add(%q): %v", test.add, err)
+		}
+		c.Remove(test.remove)
+		if c.Len() != 0 {
+			t.Errorf("Remove(%q) after Add(%q) left %v clients", test.remove, test.add, c.Len())
+		}
+	}
+}
+
+func TestClientRegistryAddReplaces(t *testing.T) {
+
+	c := NewClientRegistry()
+	c.Add("10.0.0.0/8", "old", "secret", "")
+	c.Add("10.1.2.3/8", "new", "secret", "")
+	c.Add("10.0.0.1", "host", "secret", "")
+	c.Add("10.0.0.1/32", "host2", "secret", "")
+
+	if c.Len() != 2 {
+		t.Fatalf("got %v clients, want 2", c.Len())
+	}
+
+	if client, _ := c.Find(net.ParseIP("10.9.9.9")); client == nil || client.Name != "new" {
+		t.Errorf("range not replaced: %v", client)
+	}
+	if client, _ := c.Find(net.ParseIP("10.0.0.1")); client == nil || client.Name != "host2" {
+		t.Errorf("host not replaced: %v", client)
+	}
+}
+
+func TestClientRegistryZeroValue(t *testing.T) {
+
+	c := &ClientRegistry{}
+	if _, ok := c.Find(net.ParseIP("10.0.0.1")); ok {
+		t.Errorf("empty registry found a client")
+	}
+
+	if _, err := c.Add("10.0.0.1", "nas", "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Add("10.1.0.0/16", "range", "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if client, ok := c.Find(net.ParseIP("10.0.0.1")); !ok || client.Name != "nas" {
+		t.Errorf("got %v %v, want nas", client, ok)
+	}
+	if client, ok := c.Find(net.ParseIP("10.1.2.3")); !ok || client.Name != "range" {
+		t.Errorf("got %v %v, want range", client, ok)
+	}
+}
@@ -0,0 +1,79 @@
+package goradius
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// CoAPort is the port NASes listen on for Dynamic Authorization requests
+// (RFC 5176).
+const CoAPort = 3799
+
+func NewDisconnectRequest() *RadiusPacket {
+
+	p := NewRadiusPacket()
+	p.Code = DisconnectRequest
+
+	return p
+}
+
+func NewCoARequest() *RadiusPacket {
+
+	p := NewRadiusPacket()
+	p.Code = CoARequest
+
+	return p
+}
+
+// SendCoA sends a CoA-Request or Disconnect-Request to a NAS. host can omit
+// the port, CoAPort is used then.
+func (c *Client) SendCoA(ctx context.Context, packet *RadiusPacket, host string) (*RadiusPacket, error) {
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(CoAPort))
+	}
+
+	return c.Exchange(ctx, packet, host)
+}
+
+// SetErrorCause adds an Error-Cause attribute, used in Disconnect-NAK and
+// CoA-NAK replies.
+func (p *RadiusPacket) SetErrorCause(cause uint32) {
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, cause)
+	p.AddAttributeByType(ErrorCause, value)
+
+}
+
+// ProxyHandler forwards requests to the server returned by target using
+// client and copies the reply into the response. It is meant for relaying
+// CoA and Disconnect requests to the NAS holding the session, but works for
+// any request the client can send. When the NAS doesn't answer the request
+// is dropped.
+func ProxyHandler(client *Client, target func(req *RadiusPacket) (string, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+
+		addr, err := target(req)
+		if err != nil {
+			return err
+		}
+
+		reply, err := client.Exchange(ctx, req.ProxyRequest(), addr)
+		if err != nil {
+			return err
+		}
+
+		res := w.Response()
+		res.Code = reply.Code
+		for _, attr := range reply.Attributes {
+			if attr.Type != MessageAuthenticator {
+				res.Attributes = append(res.Attributes, attr)
+			}
+		}
+
+		return nil
+	})
+}
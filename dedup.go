@@ -0,0 +1,79 @@
+package goradius
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDuplicateTTL is how long NewRadiusServer remembers a request to
+// detect retransmissions.
+const DefaultDuplicateTTL = 5 * time.Second
+
+type duplicateEntry struct {
+	done     bool
+	response []byte
+	expires  time.Time
+}
+
+// duplicateCache remembers recently seen requests by (client address, port,
+// Identifier, Request Authenticator) together with the encoded reply.
+type duplicateCache struct {
+	lock      sync.Mutex
+	entries   map[string]*duplicateEntry
+	lastSweep time.Time
+}
+
+func newDuplicateCache() *duplicateCache {
+
+	d := duplicateCache{}
+	d.entries = make(map[string]*duplicateEntry)
+
+	return &d
+}
+
+func duplicateKey(addr *net.UDPAddr, identifier uint8, authenticator [authenticatorLength]byte) string {
+	return addr.String() + "/" + string([]byte{identifier}) + string(authenticator[:])
+}
+
+// begin returns the cached entry for key, or stores a new in-flight entry and
+// returns nil if the request was not seen before.
+func (d *duplicateCache) begin(key string, ttl time.Duration) *duplicateEntry {
+
+	now := time.Now()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if now.Sub(d.lastSweep) > ttl {
+		for k, e := range d.entries {
+			if e.done && now.After(e.expires) {
+				delete(d.entries, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if entry, ok := d.entries[key]; ok && (!entry.done || now.Before(entry.expires)) {
+		cached := *entry
+		return &cached
+	}
+
+	d.entries[key] = &duplicateEntry{}
+
+	return nil
+}
+
+// finish stores the encoded response for key, nil if the request was
+// dropped, and starts the expiry timer.
+func (d *duplicateCache) finish(key string, response []byte, ttl time.Duration) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.entries[key] = &duplicateEntry{
+		done:     true,
+		response: response,
+		expires:  time.Now().Add(ttl),
+	}
+}
@@ -0,0 +1,88 @@
+package goradius
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingServer(calls *int32) *RadiusServer {
+
+	r := NewRadiusServer('a')
+	r.HandleFunc(AccessRequest, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		atomic.AddInt32(calls, 1)
+		w.Response().Code = AccessAccept
+		return nil
+	})
+
+	return r
+}
+
+func TestDuplicateReplayed(t *testing.T) {
+
+	var calls int32
+	r := countingServer(&calls)
+	r.DuplicateTTL = 100 * time.Millisecond
+	client := &RadiusClient{Name: "nas", Secret: "secret"}
+	raw := accessRequest(t, "secret", true)
+
+	first := handleRaw(r, client, raw)
+	second := handleRaw(r, client, raw)
+	if first == nil || !bytes.Equal(first, second) {
+		t.Errorf("retransmission got %x, want the first reply %x", second, first)
+	}
+	if calls != 1 || r.Replayed() != 1 {
+		t.Errorf("handled %v times, %v replayed", calls, r.Replayed())
+	}
+
+	// another request from the same NAS with the same Identifier
+	if handleRaw(r, client, accessRequest(t, "secret", true)) == nil || calls != 2 {
+		t.Errorf("new request taken for a retransmission")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if handleRaw(r, client, raw) == nil || calls != 3 {
+		t.Errorf("request not handled again after DuplicateTTL")
+	}
+}
+
+// A retransmission that comes while the first copy is still being handled
+// is dropped, the NAS gets the one reply.
+func TestDuplicateInFlight(t *testing.T) {
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	r := NewRadiusServer('a')
+	r.HandleFunc(AccessRequest, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		entered <- struct{}{}
+		<-release
+		w.Response().Code = AccessAccept
+		return nil
+	})
+	client := &RadiusClient{Name: "nas", Secret: "secret"}
+	raw := accessRequest(t, "secret", true)
+
+	done := make(chan []byte)
+	go func() {
+		done <- handleRaw(r, client, raw)
+	}()
+	<-entered
+
+	if reply := handleRaw(r, client, raw); reply != nil {
+		t.Errorf("duplicate answered while the first copy was handled")
+	}
+	if dropped := r.Dropped(DropDuplicate); dropped != 1 {
+		t.Errorf("%v duplicates counted, want 1", dropped)
+	}
+
+	close(release)
+	if reply := <-done; reply == nil {
+		t.Errorf("first copy not answered")
+	}
+	if r.Replayed() != 0 {
+		t.Errorf("in-flight duplicate counted as replayed")
+	}
+}
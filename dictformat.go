@@ -0,0 +1,87 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// formatValue prints an attribute value according to its dictionary type.
+// Values that don't fit the type are printed in hex.
+func formatValue(def *DictAttribute, value []byte) string {
+
+	switch def.Type {
+	case TypeString:
+		if def.Encrypt == EncryptNone {
+			return strconv.Quote(string(value))
+		}
+	case TypeInteger, TypeDate, TypeSigned:
+		if len(value) != 4 {
+			break
+		}
+		n := binary.BigEndian.Uint32(value)
+		switch def.Type {
+		case TypeDate:
+			return time.Unix(int64(n), 0).UTC().Format(time.RFC3339)
+		case TypeSigned:
+			return strconv.FormatInt(int64(int32(n)), 10)
+		}
+		return formatEnum(def, uint64(n))
+	case TypeByte:
+		if len(value) == 1 {
+			return formatEnum(def, uint64(value[0]))
+		}
+	case TypeShort:
+		if len(value) == 2 {
+			return formatEnum(def, uint64(binary.BigEndian.Uint16(value)))
+		}
+	case TypeInteger64:
+		if len(value) == 8 {
+			return formatEnum(def, binary.BigEndian.Uint64(value))
+		}
+	case TypeIPAddr:
+		if len(value) == net.IPv4len {
+			return net.IP(value).String()
+		}
+	case TypeIPv6Addr:
+		if len(value) == net.IPv6len {
+			return net.IP(value).String()
+		}
+	case TypeComboIP:
+		if len(value) == net.IPv4len || len(value) == net.IPv6len {
+			return net.IP(value).String()
+		}
+	case TypeIPv6Prefix:
+		if len(value) >= 2 && len(value) <= 18 && value[1] <= 128 {
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, value[2:])
+			return fmt.Sprintf("%v/%v", ip, value[1])
+		}
+	case TypeIPv4Prefix:
+		if len(value) == 6 && value[1]&0x3f <= 32 {
+			return fmt.Sprintf("%v/%v", net.IP(value[2:]), value[1]&0x3f)
+		}
+	case TypeIfId:
+		if len(value) == 8 {
+			return fmt.Sprintf("%x:%x:%x:%x", value[0:2], value[2:4], value[4:6], value[6:8])
+		}
+	case TypeEther:
+		if len(value) == 6 {
+			return net.HardwareAddr(value).String()
+		}
+	}
+
+	return "0x" + hex.EncodeToString(value)
+}
+
+func formatEnum(def *DictAttribute, n uint64) string {
+
+	if name, ok := def.ValueName(n); ok {
+		return name
+	}
+
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,100 @@
+package goradius
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// bundledDictionaries holds the dictionary files shipped with the package.
+//
+//go:embed dictionaries
+var bundledDictionaries embed.FS
+
+const standardDictionary = "dictionaries/dictionary"
+
+// vendorDictionaries maps the lowercased vendor names to their bundled
+// dictionary file.
+var vendorDictionaries = map[string]string{
+	"3gpp":      "dictionaries/dictionary.3gpp",
+	"aruba":     "dictionaries/dictionary.aruba",
+	"cisco":     "dictionaries/dictionary.cisco",
+	"juniper":   "dictionaries/dictionary.juniper",
+	"microsoft": "dictionaries/dictionary.microsoft",
+	"mikrotik":  "dictionaries/dictionary.mikrotik",
+	"ruckus":    "dictionaries/dictionary.ruckus",
+	"wispr":     "dictionaries/dictionary.wispr",
+}
+
+// NewDictionary returns a dictionary holding the standard RADIUS attributes
+// and values (RFC 2865, 2866, 2867, 2868, 2869, 3162, 3576, 3580, 4072, 4372,
+// 4818, 5176, 6911 and 6929).
+func NewDictionary() *Dictionary {
+
+	d := NewEmptyDictionary()
+
+	// the bundled files are part of the package, failing to parse them is a
+	// bug and not something callers can handle
+	if err := d.LoadFS(bundledDictionaries, standardDictionary); err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// NewEmptyDictionary returns a dictionary without any attribute, for
+// loading a complete set of dictionary files such as FreeRADIUS's.
+func NewEmptyDictionary() *Dictionary {
+
+	d := Dictionary{}
+	d.attributes = make(map[string]*DictAttribute)
+	d.attributeOIDs = make(map[string]*DictAttribute)
+	d.vendors = make(map[string]*DictVendor)
+	d.vendorIds = make(map[uint32]*DictVendor)
+
+	return &d
+}
+
+// LoadFS reads a dictionary file from fsys, $INCLUDEs are resolved within
+// fsys.
+func (d *Dictionary) LoadFS(fsys fs.FS, file string) error {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	p := dictParser{dict: d, fsys: fsys}
+
+	return p.load(file)
+}
+
+// EnableVendor loads the bundled dictionary of a vendor into
+// DefaultDictionary.
+func EnableVendor(name string) error {
+	return DefaultDictionary.EnableVendor(name)
+}
+
+// EnableVendor loads the bundled dictionary of a vendor, see BundledVendors
+// for the names.
+func (d *Dictionary) EnableVendor(name string) error {
+
+	file, ok := vendorDictionaries[strings.ToLower(name)]
+	if !ok {
+		return errors.New("No bundled dictionary for vendor: " + name)
+	}
+
+	return d.LoadFS(bundledDictionaries, file)
+}
+
+// BundledVendors returns the names of the vendors EnableVendor knows.
+func BundledVendors() []string {
+
+	names := make([]string, 0, len(vendorDictionaries))
+	for name := range vendorDictionaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,792 @@
+package goradius
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DataType is the type of an attribute value as named in the dictionary.
+type DataType int
+
+const (
+	TypeOctets DataType = iota
+	TypeString
+	TypeIPAddr
+	TypeInteger
+	TypeDate
+	TypeIPv6Addr
+	TypeIPv6Prefix
+	TypeIfId
+	TypeInteger64
+	TypeByte
+	TypeShort
+	TypeSigned
+	TypeEther
+	TypeABinary
+	TypeComboIP
+	TypeIPv4Prefix
+	TypeTLV
+	TypeVSA
+	TypeExtended
+	TypeLongExtended
+	TypeEVS
+)
+
+var dataTypeNames = map[DataType]string{
+	TypeOctets:       "octets",
+	TypeString:       "string",
+	TypeIPAddr:       "ipaddr",
+	TypeInteger:      "integer",
+	TypeDate:         "date",
+	TypeIPv6Addr:     "ipv6addr",
+	TypeIPv6Prefix:   "ipv6prefix",
+	TypeIfId:         "ifid",
+	TypeInteger64:    "integer64",
+	TypeByte:         "byte",
+	TypeShort:        "short",
+	TypeSigned:       "signed",
+	TypeEther:        "ether",
+	TypeABinary:      "abinary",
+	TypeComboIP:      "combo-ip",
+	TypeIPv4Prefix:   "ipv4prefix",
+	TypeTLV:          "tlv",
+	TypeVSA:          "vsa",
+	TypeExtended:     "extended",
+	TypeLongExtended: "long-extended",
+	TypeEVS:          "evs",
+}
+
+// names used by newer dictionaries for the same types
+var dataTypeAliases = map[string]DataType{
+	"uint8":    TypeByte,
+	"uint16":   TypeShort,
+	"uint32":   TypeInteger,
+	"uint64":   TypeInteger64,
+	"int32":    TypeSigned,
+	"ipv4addr": TypeIPAddr,
+}
+
+func (t DataType) String() string {
+	return dataTypeNames[t]
+}
+
+func parseDataType(name string) (DataType, bool) {
+
+	for t, n := range dataTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+
+	t, ok := dataTypeAliases[name]
+
+	return t, ok
+}
+
+// Encryption schemes of the dictionary encrypt= flag
+const (
+	EncryptNone         = 0
+	EncryptUserPassword = 1 // RFC 2865 §5.2
+	EncryptTunnel       = 2 // RFC 2868 §3.5
+	EncryptAscendSecret = 3
+)
+
+// DictVendor is a VENDOR entry.
+type DictVendor struct {
+	Name string
+	Id   uint32
+
+	// Size in bytes of the type and length fields of the vendor's
+	// attributes, from format=t,l. Most vendors use 1,1.
+	TypeLength   int
+	LengthLength int
+	Continuation bool
+
+	// Extended is the Extended-Type attribute (241-246) whose
+	// Extended-Vendor-Specific holds the vendor's attributes, 0 when they
+	// go in a plain Vendor-Specific.
+	Extended uint8
+}
+
+// DictAttribute is an ATTRIBUTE entry with its VALUEs.
+type DictAttribute struct {
+	Name string
+
+	// OID is the attribute number. It has more than one element for
+	// attributes nested in extended or tlv attributes, e.g. 241.1.
+	// Vendor attributes are numbered inside their vendor.
+	OID    []uint32
+	Vendor *DictVendor
+	Type   DataType
+
+	// Length is the fixed size of "octets[N]" attributes, 0 otherwise.
+	Length int
+
+	Encrypt int
+	HasTag  bool
+	Concat  bool
+	Array   bool
+	Virtual bool
+
+	values     map[string]uint64
+	valueNames map[uint64]string
+}
+
+// Code returns the last element of the OID, which is what goes in the type
+// field of the encoded attribute.
+func (a *DictAttribute) Code() uint32 {
+	return a.OID[len(a.OID)-1]
+}
+
+func (a *DictAttribute) VendorId() uint32 {
+
+	if a.Vendor == nil {
+		return 0
+	}
+
+	return a.Vendor.Id
+}
+
+// Value returns the number of an enumerated VALUE.
+func (a *DictAttribute) Value(name string) (uint64, bool) {
+	v, ok := a.values[strings.ToLower(name)]
+	return v, ok
+}
+
+// ValueName returns the name of an enumerated VALUE.
+func (a *DictAttribute) ValueName(value uint64) (string, bool) {
+	n, ok := a.valueNames[value]
+	return n, ok
+}
+
+func (a *DictAttribute) addValue(name string, value uint64) {
+
+	if a.values == nil {
+		a.values = make(map[string]uint64)
+		a.valueNames = make(map[uint64]string)
+	}
+
+	a.values[strings.ToLower(name)] = value
+
+	// the first name given to a number is the one printed
+	if _, exists := a.valueNames[value]; !exists {
+		a.valueNames[value] = name
+	}
+}
+
+func (a *DictAttribute) String() string {
+	return fmt.Sprintf("%v %v %v", a.Name, oidString(a.OID), a.Type)
+}
+
+// DictionaryError is returned for invalid dictionary files.
+type DictionaryError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *DictionaryError) Error() string {
+	return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Err)
+}
+
+func (e *DictionaryError) Unwrap() error {
+	return e.Err
+}
+
+// Dictionary holds the attributes, vendors and values loaded from
+// FreeRADIUS dictionary files. Servers, clients and packets use
+// DefaultDictionary unless they are given their own.
+type Dictionary struct {
+	lock          sync.RWMutex
+	attributes    map[string]*DictAttribute
+	attributeOIDs map[string]*DictAttribute
+	vendors       map[string]*DictVendor
+	vendorIds     map[uint32]*DictVendor
+}
+
+var DefaultDictionary = NewDictionary()
+
+func oidString(oid []uint32) string {
+
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func oidKey(vendorId uint32, oid []uint32) string {
+	return strconv.FormatUint(uint64(vendorId), 10) + ":" + oidString(oid)
+}
+
+// LoadDictionary loads a dictionary file into DefaultDictionary.
+func LoadDictionary(file string) error {
+	return DefaultDictionary.Load(file)
+}
+
+// FindAttribute looks up an attribute of DefaultDictionary by name.
+func FindAttribute(name string) (*DictAttribute, bool) {
+	return DefaultDictionary.FindAttribute(name)
+}
+
+// FindAttributeByOID looks up an attribute of DefaultDictionary by number.
+func FindAttributeByOID(vendorId uint32, oid ...uint32) (*DictAttribute, bool) {
+	return DefaultDictionary.FindAttributeByOID(vendorId, oid...)
+}
+
+// FindVendor looks up a vendor of DefaultDictionary by name.
+func FindVendor(name string) (*DictVendor, bool) {
+	return DefaultDictionary.FindVendor(name)
+}
+
+// Load reads a FreeRADIUS dictionary file, following its $INCLUDEs.
+func (d *Dictionary) Load(file string) error {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	p := dictParser{dict: d}
+
+	return p.load(file)
+}
+
+// FindAttribute looks up an attribute by name.
+func (d *Dictionary) FindAttribute(name string) (*DictAttribute, bool) {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	attr, ok := d.attributes[strings.ToLower(name)]
+
+	return attr, ok
+}
+
+// FindAttributeByOID looks up an attribute by vendor and number, vendorId
+// is 0 for standard attributes.
+func (d *Dictionary) FindAttributeByOID(vendorId uint32, oid ...uint32) (*DictAttribute, bool) {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	attr, ok := d.attributeOIDs[oidKey(vendorId, oid)]
+
+	return attr, ok
+}
+
+// FindVendor looks up a vendor by name.
+func (d *Dictionary) FindVendor(name string) (*DictVendor, bool) {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	vendor, ok := d.vendors[strings.ToLower(name)]
+
+	return vendor, ok
+}
+
+// FindVendorById looks up a vendor by its SMI Private Enterprise Code.
+func (d *Dictionary) FindVendorById(id uint32) (*DictVendor, bool) {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	vendor, ok := d.vendorIds[id]
+
+	return vendor, ok
+}
+
+// Attributes returns every attribute in the dictionary.
+func (d *Dictionary) Attributes() []*DictAttribute {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	attrs := make([]*DictAttribute, 0, len(d.attributeOIDs))
+	for _, attr := range d.attributeOIDs {
+		attrs = append(attrs, attr)
+	}
+
+	return attrs
+}
+
+// Vendors returns every vendor in the dictionary.
+func (d *Dictionary) Vendors() []*DictVendor {
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	vendors := make([]*DictVendor, 0, len(d.vendorIds))
+	for _, vendor := range d.vendorIds {
+		vendors = append(vendors, vendor)
+	}
+
+	return vendors
+}
+
+// attributeOf returns the dictionary entry describing attr, if any.
+func (d *Dictionary) attributeOf(attr RadiusAttribute) (*DictAttribute, bool) {
+
+	id := attr.ID()
+
+	return d.FindAttributeByOID(id.VendorId, id.OID...)
+}
+
+const maxIncludeDepth = 32
+
+var dictFlags = map[string]bool{
+	"has_tag":  true,
+	"concat":   true,
+	"array":    true,
+	"virtual":  true,
+	"internal": true,
+}
+
+type pendingValue struct {
+	file      string
+	line      int
+	attribute string
+	name      string
+	value     uint64
+}
+
+type dictParser struct {
+	dict *Dictionary
+
+	// fsys is where the files are read from, the OS when nil
+	fsys fs.FS
+
+	vendor        *DictVendor
+	tlvs          []*DictAttribute
+	pendingValues []pendingValue
+	depth         int
+
+	// position of the line being parsed
+	file string
+	line int
+}
+
+func (p *dictParser) readFile(file string) ([]byte, error) {
+
+	if p.fsys != nil {
+		return fs.ReadFile(p.fsys, file)
+	}
+
+	return os.ReadFile(file)
+}
+
+func (p *dictParser) includePath(current, include string) string {
+
+	if p.fsys != nil {
+		if path.IsAbs(include) {
+			return strings.TrimPrefix(include, "/")
+		}
+		return path.Join(path.Dir(current), include)
+	}
+
+	if filepath.IsAbs(include) {
+		return include
+	}
+
+	return filepath.Join(filepath.Dir(current), include)
+}
+
+// load parses file and then resolves the VALUEs that were given before
+// their ATTRIBUTE.
+func (p *dictParser) load(file string) error {
+
+	if err := p.parseFile(file); err != nil {
+		return err
+	}
+
+	for _, v := range p.pendingValues {
+		attr, ok := p.dict.attributes[strings.ToLower(v.attribute)]
+		if !ok {
+			return &DictionaryError{v.file, v.line, fmt.Errorf("VALUE for unknown attribute %v", v.attribute)}
+		}
+		attr.addValue(v.name, v.value)
+	}
+	p.pendingValues = nil
+
+	return nil
+}
+
+func (p *dictParser) parseFile(file string) error {
+
+	data, err := p.readFile(file)
+	if err != nil {
+		return err
+	}
+
+	vendor := p.vendor
+	tlvs := len(p.tlvs)
+
+	// an unclosed BEGIN-VENDOR or BEGIN-TLV is reported on the last line,
+	// not on the empty one after the final newline
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+
+		if pos := strings.IndexByte(line, '#'); pos >= 0 {
+			line = line[:pos]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		p.file, p.line = file, i+1
+		if err := p.parseLine(file, fields); err != nil {
+			if _, nested := err.(*DictionaryError); nested {
+				return err
+			}
+			return &DictionaryError{file, i + 1, err}
+		}
+	}
+
+	if p.vendor != vendor {
+		return &DictionaryError{file, len(lines), fmt.Errorf("BEGIN-VENDOR %v without END-VENDOR", p.vendor.Name)}
+	}
+
+	if len(p.tlvs) != tlvs {
+		return &DictionaryError{file, len(lines), fmt.Errorf("BEGIN-TLV %v without END-TLV", p.tlvs[len(p.tlvs)-1].Name)}
+	}
+
+	return nil
+}
+
+func (p *dictParser) parseLine(file string, fields []string) error {
+
+	switch fields[0] {
+	case "ATTRIBUTE":
+		return p.parseAttribute(fields)
+	case "VALUE":
+		return p.parseValue(fields)
+	case "VENDOR":
+		return p.parseVendor(fields)
+	case "BEGIN-VENDOR":
+		return p.parseBeginVendor(fields)
+	case "END-VENDOR":
+		if len(fields) < 2 || p.vendor == nil || !strings.EqualFold(fields[1], p.vendor.Name) {
+			return errors.New("END-VENDOR doesn't match BEGIN-VENDOR")
+		}
+		p.vendor = nil
+	case "BEGIN-TLV":
+		if len(fields) < 2 {
+			return errors.New("BEGIN-TLV needs an attribute")
+		}
+		attr, ok := p.dict.attributes[strings.ToLower(fields[1])]
+		if !ok || attr.Type != TypeTLV {
+			return fmt.Errorf("BEGIN-TLV %v is not a tlv attribute", fields[1])
+		}
+		p.tlvs = append(p.tlvs, attr)
+	case "END-TLV":
+		if len(fields) < 2 || len(p.tlvs) == 0 || !strings.EqualFold(fields[1], p.tlvs[len(p.tlvs)-1].Name) {
+			return errors.New("END-TLV doesn't match BEGIN-TLV")
+		}
+		p.tlvs = p.tlvs[:len(p.tlvs)-1]
+	case "$INCLUDE", "$INCLUDE-":
+		if len(fields) < 2 {
+			return errors.New("$INCLUDE needs a file")
+		}
+		if p.depth >= maxIncludeDepth {
+			return errors.New("$INCLUDE nested too deep")
+		}
+		include := p.includePath(file, fields[1])
+		p.depth++
+		err := p.parseFile(include)
+		p.depth--
+		if err != nil && fields[0] == "$INCLUDE-" && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	case "FLAGS", "PROTOCOL", "BEGIN-PROTOCOL", "END-PROTOCOL":
+		// only meaningful to FreeRADIUS itself
+	default:
+		return fmt.Errorf("Unknown keyword %v", fields[0])
+	}
+
+	return nil
+}
+
+func parseNumber(s string, bits int) (uint64, error) {
+
+	n, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %v", s)
+	}
+
+	return n, nil
+}
+
+func parseOID(s string) ([]uint32, error) {
+
+	var oid []uint32
+	for _, part := range strings.Split(s, ".") {
+		n, err := parseNumber(part, 32)
+		if err != nil {
+			return nil, err
+		}
+		oid = append(oid, uint32(n))
+	}
+
+	return oid, nil
+}
+
+// ATTRIBUTE name oid type [vendor|flags]
+func (p *dictParser) parseAttribute(fields []string) error {
+
+	if len(fields) < 4 {
+		return errors.New("ATTRIBUTE needs a name, a number and a type")
+	}
+
+	attr := &DictAttribute{
+		Name:   fields[1],
+		Vendor: p.vendor,
+	}
+
+	oid, err := parseOID(fields[2])
+	if err != nil {
+		return err
+	}
+
+	if len(p.tlvs) > 0 && len(oid) == 1 {
+		parent := p.tlvs[len(p.tlvs)-1]
+		oid = append(append([]uint32{}, parent.OID...), oid[0])
+	}
+	attr.OID = oid
+
+	typeName := fields[3]
+	if pos := strings.IndexByte(typeName, '['); pos > 0 && strings.HasSuffix(typeName, "]") {
+		length, err := parseNumber(typeName[pos+1:len(typeName)-1], 8)
+		if err != nil {
+			return err
+		}
+		attr.Length = int(length)
+		typeName = typeName[:pos]
+	}
+
+	dataType, ok := parseDataType(typeName)
+	if !ok {
+		return fmt.Errorf("Unknown data type %v", fields[3])
+	}
+	attr.Type = dataType
+
+	if len(fields) > 4 {
+		if err := p.parseAttributeOptions(attr, fields[4]); err != nil {
+			return err
+		}
+	}
+
+	if attr.Vendor != nil {
+		maxCode := uint64(1)<<(8*uint(attr.Vendor.TypeLength)) - 1
+		if attr.Vendor.TypeLength < 4 && uint64(oid[0]) > maxCode {
+			return fmt.Errorf("Attribute number %v too big for vendor %v", oid[0], attr.Vendor.Name)
+		}
+	} else if oid[0] > 255 {
+		return fmt.Errorf("Attribute number %v too big", oid[0])
+	}
+
+	return p.dict.addAttribute(attr)
+}
+
+func (p *dictParser) parseAttributeOptions(attr *DictAttribute, options string) error {
+
+	if !strings.Contains(options, "=") && !dictFlags[strings.Split(options, ",")[0]] {
+		// old style: the fifth field is the vendor
+		vendor, ok := p.dict.vendors[strings.ToLower(options)]
+		if !ok {
+			return fmt.Errorf("Unknown vendor %v", options)
+		}
+		attr.Vendor = vendor
+		return nil
+	}
+
+	for _, flag := range strings.Split(options, ",") {
+
+		name, value := flag, ""
+		if pos := strings.IndexByte(flag, '='); pos >= 0 {
+			name, value = flag[:pos], flag[pos+1:]
+		}
+
+		switch name {
+		case "has_tag":
+			attr.HasTag = true
+		case "concat":
+			attr.Concat = true
+		case "array":
+			attr.Array = true
+		case "virtual", "internal":
+			attr.Virtual = true
+		case "encrypt":
+			n, err := parseNumber(value, 8)
+			if err != nil || n > EncryptAscendSecret {
+				return fmt.Errorf("Invalid encrypt=%v", value)
+			}
+			attr.Encrypt = int(n)
+		default:
+			return fmt.Errorf("Unknown attribute flag %v", name)
+		}
+	}
+
+	return nil
+}
+
+// VALUE attribute name number
+func (p *dictParser) parseValue(fields []string) error {
+
+	if len(fields) < 4 {
+		return errors.New("VALUE needs an attribute, a name and a number")
+	}
+
+	value, err := parseNumber(fields[3], 64)
+	if err != nil {
+		return err
+	}
+
+	attr, ok := p.dict.attributes[strings.ToLower(fields[1])]
+	if !ok {
+		// VALUEs can come before their ATTRIBUTE
+		p.pendingValues = append(p.pendingValues, pendingValue{p.file, p.line, fields[1], fields[2], value})
+		return nil
+	}
+
+	attr.addValue(fields[2], value)
+
+	return nil
+}
+
+// VENDOR name id [format=t,l[,c]]
+func (p *dictParser) parseVendor(fields []string) error {
+
+	if len(fields) < 3 {
+		return errors.New("VENDOR needs a name and a number")
+	}
+
+	id, err := parseNumber(fields[2], 32)
+	if err != nil {
+		return err
+	}
+
+	vendor := &DictVendor{
+		Name:         fields[1],
+		Id:           uint32(id),
+		TypeLength:   1,
+		LengthLength: 1,
+	}
+
+	if len(fields) > 3 {
+
+		if !strings.HasPrefix(fields[3], "format=") {
+			return fmt.Errorf("Unknown vendor option %v", fields[3])
+		}
+
+		format := strings.Split(strings.TrimPrefix(fields[3], "format="), ",")
+		if len(format) < 2 || len(format) > 3 {
+			return fmt.Errorf("Invalid %v", fields[3])
+		}
+
+		typeLength, err1 := strconv.Atoi(format[0])
+		lengthLength, err2 := strconv.Atoi(format[1])
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("Invalid %v", fields[3])
+		}
+
+		if typeLength != 1 && typeLength != 2 && typeLength != 4 {
+			return fmt.Errorf("Invalid vendor type length %v", typeLength)
+		}
+
+		if lengthLength < 0 || lengthLength > 2 {
+			return fmt.Errorf("Invalid vendor length length %v", lengthLength)
+		}
+
+		if len(format) == 3 {
+			if format[2] != "c" || typeLength != 1 || lengthLength != 1 {
+				return fmt.Errorf("Invalid %v", fields[3])
+			}
+			vendor.Continuation = true
+		}
+
+		vendor.TypeLength = typeLength
+		vendor.LengthLength = lengthLength
+	}
+
+	p.dict.addVendor(vendor)
+
+	return nil
+}
+
+// BEGIN-VENDOR name [format=Extended-Vendor-Specific-N]
+func (p *dictParser) parseBeginVendor(fields []string) error {
+
+	if len(fields) < 2 {
+		return errors.New("BEGIN-VENDOR needs a vendor")
+	}
+
+	if p.vendor != nil {
+		return fmt.Errorf("BEGIN-VENDOR %v inside BEGIN-VENDOR %v", fields[1], p.vendor.Name)
+	}
+
+	vendor, ok := p.dict.vendors[strings.ToLower(fields[1])]
+	if !ok {
+		return fmt.Errorf("Unknown vendor %v", fields[1])
+	}
+
+	if len(fields) > 2 {
+
+		option := fields[2]
+		option = strings.TrimPrefix(option, "format=")
+		option = strings.TrimPrefix(option, "parent=")
+
+		if !strings.HasPrefix(option, "Extended-Vendor-Specific-") {
+			return fmt.Errorf("Unknown BEGIN-VENDOR option %v", fields[2])
+		}
+
+		n, err := parseNumber(strings.TrimPrefix(option, "Extended-Vendor-Specific-"), 8)
+		if err != nil || n < 1 || n > 6 {
+			return fmt.Errorf("Unknown BEGIN-VENDOR option %v", fields[2])
+		}
+
+		vendor.Extended = uint8(240 + n)
+	}
+
+	p.vendor = vendor
+
+	return nil
+}
+
+func (d *Dictionary) addVendor(vendor *DictVendor) {
+
+	if old, ok := d.vendorIds[vendor.Id]; ok {
+		delete(d.vendors, strings.ToLower(old.Name))
+	}
+
+	d.vendors[strings.ToLower(vendor.Name)] = vendor
+	d.vendorIds[vendor.Id] = vendor
+}
+
+func (d *Dictionary) addAttribute(attr *DictAttribute) error {
+
+	key := oidKey(attr.VendorId(), attr.OID)
+	name := strings.ToLower(attr.Name)
+
+	if old, ok := d.attributes[name]; ok {
+		if oidKey(old.VendorId(), old.OID) != key {
+			return fmt.Errorf("Duplicate attribute %v", attr.Name)
+		}
+		// loading the same definition again keeps its values
+		attr.values, attr.valueNames = old.values, old.valueNames
+	}
+
+	d.attributes[name] = attr
+	d.attributeOIDs[key] = attr
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package goradius
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func dictionaryFS(files map[string]string) fstest.MapFS {
+
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+
+	return fsys
+}
+
+func TestDictionaryErrors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		file  string
+		line  int
+		err   string
+	}{
+		{
+			"unknown keyword",
+			map[string]string{"dictionary": "# comment\n\nATTRIBUTES Foo 1 string\n"},
+			"dictionary", 3, "Unknown keyword ATTRIBUTES",
+		},
+		{
+			"error in nested include",
+			map[string]string{
+				"dictionary":       "ATTRIBUTE Foo 1 string\n$INCLUDE sub/dictionary.a\n",
+				"sub/dictionary.a": "$INCLUDE dictionary.b\n",
+				"sub/dictionary.b": "ATTRIBUTE Bar 2 integer\n\nATTRIBUTE Baz 3 float\n",
+			},
+			"sub/dictionary.b", 3, "Unknown data type float",
+		},
+		{
+			"missing include",
+			map[string]string{"dictionary": "ATTRIBUTE Foo 1 string\n$INCLUDE dictionary.missing\n"},
+			"dictionary", 2, "",
+		},
+		{
+			"include loop",
+			map[string]string{"dictionary": "$INCLUDE dictionary\n"},
+			"dictionary", 1, "$INCLUDE nested too deep",
+		},
+		{
+			"END-VENDOR of another vendor",
+			map[string]string{"dictionary": "VENDOR Foo 9\nVENDOR Bar 10\nBEGIN-VENDOR Foo\nEND-VENDOR Bar\n"},
+			"dictionary", 4, "END-VENDOR doesn't match BEGIN-VENDOR",
+		},
+		{
+			"END-VENDOR without BEGIN-VENDOR",
+			map[string]string{"dictionary": "VENDOR Foo 9\nEND-VENDOR Foo\n"},
+			"dictionary", 2, "END-VENDOR doesn't match BEGIN-VENDOR",
+		},
+		{
+			"BEGIN-VENDOR without END-VENDOR",
+			map[string]string{"dictionary": "VENDOR Foo 9\nBEGIN-VENDOR Foo\nATTRIBUTE Foo-Bar 1 string\n"},
+			"dictionary", 3, "BEGIN-VENDOR Foo without END-VENDOR",
+		},
+		{
+			"BEGIN-VENDOR inside BEGIN-VENDOR",
+			map[string]string{"dictionary": "VENDOR Foo 9\nVENDOR Bar 10\nBEGIN-VENDOR Foo\nBEGIN-VENDOR Bar\n"},
+			"dictionary", 4, "BEGIN-VENDOR Bar inside BEGIN-VENDOR Foo",
+		},
+		{
+			"format type length",
+			map[string]string{"dictionary": "VENDOR Foo 9 format=3,1\n"},
+			"dictionary", 1, "Invalid vendor type length 3",
+		},
+		{
+			"format length length",
+			map[string]string{"dictionary": "VENDOR Foo 9 format=2,3\n"},
+			"dictionary", 1, "Invalid vendor length length 3",
+		},
+		{
+			"format not numbers",
+			map[string]string{"dictionary": "VENDOR Foo 9 format=a,b\n"},
+			"dictionary", 1, "Invalid format=a,b",
+		},
+		{
+			"format continuation of wide vendor",
+			map[string]string{"dictionary": "VENDOR Foo 9 format=2,1,c\n"},
+			"dictionary", 1, "Invalid format=2,1,c",
+		},
+		{
+			"format one field",
+			map[string]string{"dictionary": "VENDOR Foo 9 format=1\n"},
+			"dictionary", 1, "Invalid format=1",
+		},
+		{
+			"VALUE without ATTRIBUTE",
+			map[string]string{"dictionary": "ATTRIBUTE Foo 1 integer\nVALUE Bar Baz 1\nVALUE Foo Baz 1\n"},
+			"dictionary", 2, "VALUE for unknown attribute Bar",
+		},
+		{
+			"old style unknown vendor",
+			map[string]string{"dictionary": "ATTRIBUTE Foo-Bar 1 string Foo\n"},
+			"dictionary", 1, "Unknown vendor Foo",
+		},
+	}
+
+	for _, tt := range tests {
+
+		err := NewEmptyDictionary().LoadFS(dictionaryFS(tt.files), "dictionary")
+
+		var dictErr *DictionaryError
+		if !errors.As(err, &dictErr) {
+			t.Errorf("%v: got %v, want a DictionaryError", tt.name, err)
+			continue
+		}
+
+		if dictErr.File != tt.file || dictErr.Line != tt.line {
+			t.Errorf("%v: error at %v:%v, want %v:%v", tt.name, dictErr.File, dictErr.Line, tt.file, tt.line)
+		}
+
+		if tt.err == "" {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%v: got %v, want fs.ErrNotExist", tt.name, err)
+			}
+		} else if !strings.HasSuffix(err.Error(), ": "+tt.err) {
+			t.Errorf("%v: got %q, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDictionaryLoad(t *testing.T) {
+
+	fsys := dictionaryFS(map[string]string{
+		"dictionary": `
+VALUE	Acme-Mode	Fast	2	# before its ATTRIBUTE
+$INCLUDE	vendors/dictionary.acme
+$INCLUDE-	dictionary.local
+ATTRIBUTE	Plain	1	string
+`,
+		"vendors/dictionary.acme": `
+VENDOR		Acme	9999
+$INCLUDE	dictionary.wide
+ATTRIBUTE	Acme-Mode	1	integer	Acme
+VALUE	Acme-Mode	Slow	1
+`,
+		"vendors/dictionary.wide": `
+VENDOR		Wide	9998	format=2,2
+BEGIN-VENDOR	Wide
+ATTRIBUTE	Wide-Port	300	integer
+END-VENDOR	Wide
+`,
+	})
+
+	d := NewEmptyDictionary()
+	if err := d.LoadFS(fsys, "dictionary"); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, ok := d.FindAttribute("Plain")
+	if !ok || plain.Vendor != nil || plain.Code() != 1 || plain.Type != TypeString {
+		t.Errorf("got %+v %v for Plain", plain, ok)
+	}
+
+	// old style: the vendor in the fifth column
+	mode, ok := d.FindAttributeByOID(9999, 1)
+	if !ok || mode.Name != "Acme-Mode" || mode.VendorId() != 9999 || mode.Type != TypeInteger {
+		t.Fatalf("got %+v %v for Acme-Mode", mode, ok)
+	}
+	for name, want := range map[string]uint64{"Fast": 2, "Slow": 1} {
+		if value, ok := mode.Value(name); !ok || value != want {
+			t.Errorf("got %v %v for VALUE %v, want %v", value, ok, name, want)
+		}
+	}
+
+	port, ok := d.FindAttribute("Wide-Port")
+	if !ok || port.VendorId() != 9998 || port.Code() != 300 {
+		t.Errorf("got %+v %v for Wide-Port", port, ok)
+	}
+	if wide, ok := d.FindVendorById(9998); !ok || wide.TypeLength != 2 || wide.LengthLength != 2 {
+		t.Errorf("got %+v %v for vendor Wide", wide, ok)
+	}
+}
@@ -0,0 +1,448 @@
+package goradius
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// EAP codes (RFC 3748 §4)
+const (
+	EAPRequest  = uint8(1)
+	EAPResponse = uint8(2)
+	EAPSuccess  = uint8(3)
+	EAPFailure  = uint8(4)
+)
+
+// EAP method types
+const (
+	EAPTypeIdentity     = uint8(1)
+	EAPTypeNotification = uint8(2)
+	EAPTypeNak          = uint8(3)
+	EAPTypeMD5Challenge = uint8(4)
+	EAPTypeTLS          = uint8(13)
+	EAPTypeTTLS         = uint8(21)
+	EAPTypePEAP         = uint8(25)
+	EAPTypeMSCHAPv2     = uint8(26)
+)
+
+const eapHeaderLength = 4
+
+// DefaultEAPTimeout is how long an EAP conversation waits for the next
+// response of the peer.
+const DefaultEAPTimeout = 30 * time.Second
+
+var ErrBadEAPPacket = errors.New("Invalid EAP packet.")
+
+// EAPPacket is an EAP packet, Type and Data are only used by requests and
+// responses.
+type EAPPacket struct {
+	Code       uint8
+	Identifier uint8
+	Type       uint8
+	Data       []byte
+}
+
+// ParseEAPPacket decodes an EAP packet, octets after its Length are ignored.
+func ParseEAPPacket(b []byte) (*EAPPacket, error) {
+
+	if len(b) < eapHeaderLength {
+		return nil, ErrBadEAPPacket
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < eapHeaderLength || length > len(b) {
+		return nil, ErrBadEAPPacket
+	}
+
+	p := EAPPacket{Code: b[0], Identifier: b[1]}
+
+	if p.Code == EAPRequest || p.Code == EAPResponse {
+		if length < eapHeaderLength+1 {
+			return nil, ErrBadEAPPacket
+		}
+		p.Type = b[4]
+		p.Data = b[5:length]
+	}
+
+	return &p, nil
+}
+
+func (p *EAPPacket) Bytes() []byte {
+
+	length := eapHeaderLength
+	if p.Code == EAPRequest || p.Code == EAPResponse {
+		length += 1 + len(p.Data)
+	}
+
+	b := make([]byte, eapHeaderLength, length)
+	b[0] = p.Code
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+
+	if p.Code == EAPRequest || p.Code == EAPResponse {
+		b = append(b, p.Type)
+		b = append(b, p.Data...)
+	}
+
+	return b
+}
+
+func standardAttribute(attrType uint8) AttributeID {
+	return AttributeID{0, []uint32{uint32(attrType)}}
+}
+
+func hasEAPMessage(p *RadiusPacket) bool {
+	return p.GetAttributeByID(standardAttribute(EAPMessage)) != nil
+}
+
+// EAPMessage returns the EAP packet carried in the EAP-Message attributes of
+// p, joined in order (RFC 3579 §3.1).
+func (p *RadiusPacket) EAPMessage() []byte {
+
+	var msg []byte
+	for _, value := range p.GetAttributeByID(standardAttribute(EAPMessage)) {
+		msg = append(msg, value...)
+	}
+
+	return msg
+}
+
+// SetEAPMessage replaces the EAP-Message attributes of p with msg, split
+// over as many attributes as needed.
+func (p *RadiusPacket) SetEAPMessage(msg []byte) {
+
+	p.RemoveAttributeByID(standardAttribute(EAPMessage))
+
+	for {
+		chunk := msg
+		if len(chunk) > maxAttributeValue {
+			chunk = chunk[:maxAttributeValue]
+		}
+		msg = msg[len(chunk):]
+
+		p.AddAttributeByType(EAPMessage, chunk)
+
+		if len(msg) == 0 {
+			return
+		}
+	}
+}
+
+// EAPMethod is an EAP authentication method. A new one is made for every
+// conversation, so it can keep its state. Methods holding resources can
+// implement io.Closer, Close is called once the method is over, abandoned
+// for another one after a Nak or the conversation failed.
+type EAPMethod interface {
+	// Start returns the Type-Data of the first EAP-Request of the method.
+	Start(ctx context.Context, s *EAPSession) ([]byte, error)
+
+	// Handle gets the Type-Data of every EAP-Response of the peer. It
+	// returns EAPRequest and the Type-Data of the next request, or
+	// EAPSuccess or EAPFailure when the authentication is over.
+	Handle(ctx context.Context, s *EAPSession, data []byte) (code uint8, next []byte, err error)
+}
+
+// NewEAPMethod returns a method for a new conversation.
+type NewEAPMethod func() EAPMethod
+
+type eapMethodEntry struct {
+	methodType uint8
+	new        NewEAPMethod
+}
+
+// EAPSession is an EAP conversation, which lasts for several
+// Access-Request / Access-Challenge round trips.
+type EAPSession struct {
+	// Identity is the peer's answer to the EAP-Request/Identity.
+	Identity string
+
+	// Identifier of the last EAP-Request sent to the peer.
+	Identifier uint8
+
+	// MSK and EMSK are set by methods that derive keys. The MSK is sent to
+	// the NAS as MS-MPPE keys on success.
+	MSK  []byte
+	EMSK []byte
+
+	// TLS is the state of the connection of the TLS based methods, e.g.
+	// to check the certificate of EAP-TLS peers.
+	TLS *tls.ConnectionState
+
+	lock       sync.Mutex
+	timeout    time.Duration
+	methodType uint8
+	method     EAPMethod
+	tried      map[uint8]bool
+}
+
+// closeMethod releases the current method, which won't be used again.
+func (s *EAPSession) closeMethod() {
+
+	if c, ok := s.method.(io.Closer); ok {
+		c.Close()
+	}
+	s.method = nil
+}
+
+// MethodType returns the type of the method being used, 0 until one was
+// started.
+func (s *EAPSession) MethodType() uint8 {
+	return s.methodType
+}
+
+type eapContextKey int
+
+const eapSessionContextKey eapContextKey = 0
+
+// EAPSessionFromContext returns the session that authenticated the request
+// ctx belongs to, for the handlers that follow an EAPServer.
+func EAPSessionFromContext(ctx context.Context) *EAPSession {
+	s, _ := ctx.Value(eapSessionContextKey).(*EAPSession)
+	return s
+}
+
+// EAPServer authenticates Access-Requests carrying EAP (RFC 3579) with the
+// registered methods, proposed in the order they were registered. Peers can
+// ask for another one with a Nak.
+type EAPServer struct {
+	// Timeout is how long a conversation waits for the next response,
+	// DefaultEAPTimeout when zero.
+	Timeout time.Duration
+
+	methods  []eapMethodEntry
+	sessions stateStore
+}
+
+func NewEAPServer() *EAPServer {
+
+	e := EAPServer{}
+
+	return &e
+}
+
+// Register adds a method of type methodType.
+func (e *EAPServer) Register(methodType uint8, method NewEAPMethod) {
+	e.methods = append(e.methods, eapMethodEntry{methodType, method})
+}
+
+func (e *EAPServer) timeout() time.Duration {
+
+	if e.Timeout == 0 {
+		return DefaultEAPTimeout
+	}
+
+	return e.Timeout
+}
+
+// Middleware answers the Access-Requests carrying an EAP-Message. While the
+// conversation goes on the reply is an Access-Challenge. When the method
+// succeeds the reply is an Access-Accept with the MS-MPPE keys and the
+// request goes on to next, which can still reject it, EAPSessionFromContext
+// gives the session. Failed authentications are rejected. Requests without
+// EAP go to next untouched.
+func (e *EAPServer) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+
+			if req.Code != AccessRequest || !hasEAPMessage(req) {
+				return next.ServeRADIUS(ctx, w, req)
+			}
+
+			return e.serve(ctx, w, req, next)
+		})
+	}
+}
+
+func (e *EAPServer) serve(ctx context.Context, w ResponseWriter, req *RadiusPacket, next Handler) error {
+
+	res := w.Response()
+	res.addMessageAuthenticator()
+
+	msg := req.EAPMessage()
+	state := req.GetFirstAttribute("State")
+
+	var session *EAPSession
+	if state != nil {
+		value, ok := e.sessions.get(state)
+		if !ok {
+			// expired, or not ours
+			return e.fail(res, nil, nil, msg)
+		}
+		session = value.(*EAPSession)
+	} else {
+		session = &EAPSession{tried: make(map[uint8]bool), timeout: e.timeout()}
+	}
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	// EAP-Start (RFC 3579 §2.1)
+	if len(msg) == 0 && state == nil {
+		return e.challenge(res, session, nil, EAPTypeIdentity, nil)
+	}
+
+	packet, err := ParseEAPPacket(msg)
+	if err != nil || packet.Code != EAPResponse {
+		return e.fail(res, session, state, msg)
+	}
+
+	if state == nil {
+		if packet.Type != EAPTypeIdentity {
+			return e.fail(res, session, nil, msg)
+		}
+		session.Identity = string(packet.Data)
+		session.Identifier = packet.Identifier
+		return e.propose(ctx, res, session, nil, nil)
+	}
+
+	if packet.Identifier != session.Identifier {
+		// a response to another request, RFC 3748 §4.1 says to discard it
+		return ErrDrop
+	}
+
+	switch {
+	case packet.Type == EAPTypeIdentity && session.method == nil:
+		session.Identity = string(packet.Data)
+		return e.propose(ctx, res, session, state, nil)
+	case packet.Type == EAPTypeNak && session.method != nil:
+		return e.propose(ctx, res, session, state, packet.Data)
+	case packet.Type != session.methodType || session.method == nil:
+		return e.fail(res, session, state, msg)
+	}
+
+	code, data, err := session.method.Handle(ctx, session, packet.Data)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case EAPRequest:
+		return e.challenge(res, session, state, session.methodType, data)
+	case EAPSuccess:
+		e.sessions.remove(state)
+		session.closeMethod()
+		return e.succeed(ctx, w, req, session, next)
+	}
+
+	return e.fail(res, session, state, msg)
+}
+
+// propose starts the first registered method that wasn't tried yet and,
+// after a Nak, is in the types the peer asked for.
+func (e *EAPServer) propose(ctx context.Context, res *RadiusPacket, session *EAPSession, state []byte, wanted []byte) error {
+
+	session.closeMethod()
+
+	for _, entry := range e.methods {
+
+		if session.tried[entry.methodType] {
+			continue
+		}
+
+		if wanted != nil && !containsType(wanted, entry.methodType) {
+			continue
+		}
+
+		session.tried[entry.methodType] = true
+		session.methodType = entry.methodType
+		session.method = entry.new()
+
+		data, err := session.method.Start(ctx, session)
+		if err != nil {
+			session.closeMethod()
+			return err
+		}
+
+		return e.challenge(res, session, state, entry.methodType, data)
+	}
+
+	log.Printf("No EAP method left for %v.", session.Identity)
+
+	return e.fail(res, session, state, nil)
+}
+
+func containsType(types []byte, t uint8) bool {
+
+	for _, c := range types {
+		if c == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// challenge sends the next EAP-Request in an Access-Challenge, under a new
+// State.
+func (e *EAPServer) challenge(res *RadiusPacket, session *EAPSession, state []byte, methodType uint8, data []byte) error {
+
+	if state != nil {
+		e.sessions.remove(state)
+	}
+
+	session.Identifier++
+
+	packet := EAPPacket{Code: EAPRequest, Identifier: session.Identifier, Type: methodType, Data: data}
+	res.Code = AccessChallenge
+	res.SetEAPMessage(packet.Bytes())
+	res.AddAttributeByType(State, e.sessions.add(session, e.timeout()))
+
+	return nil
+}
+
+// succeed accepts the request, with the keys of the method, and lets next
+// have a say.
+func (e *EAPServer) succeed(ctx context.Context, w ResponseWriter, req *RadiusPacket, session *EAPSession, next Handler) error {
+
+	res := w.Response()
+	res.Code = AccessAccept
+
+	packet := EAPPacket{Code: EAPSuccess, Identifier: session.Identifier}
+	res.SetEAPMessage(packet.Bytes())
+
+	if len(session.MSK) >= 64 {
+		res.AddAttributeByID(microsoftAttribute(msMPPERecvKey), session.MSK[:32])
+		res.AddAttributeByID(microsoftAttribute(msMPPESendKey), session.MSK[32:64])
+	}
+
+	err := next.ServeRADIUS(context.WithValue(ctx, eapSessionContextKey, session), w, req)
+	if err != nil {
+		return err
+	}
+
+	if res.Code == AccessReject {
+		res.RemoveAttributeByID(microsoftAttribute(msMPPERecvKey))
+		res.RemoveAttributeByID(microsoftAttribute(msMPPESendKey))
+		packet.Code = EAPFailure
+		res.SetEAPMessage(packet.Bytes())
+	}
+
+	return nil
+}
+
+// fail rejects the request with an EAP-Failure and ends the conversation.
+func (e *EAPServer) fail(res *RadiusPacket, session *EAPSession, state []byte, msg []byte) error {
+
+	if state != nil {
+		e.sessions.remove(state)
+	}
+
+	packet := EAPPacket{Code: EAPFailure}
+	if session != nil {
+		session.closeMethod()
+		packet.Identifier = session.Identifier
+	} else if len(msg) > 1 {
+		packet.Identifier = msg[1]
+	}
+
+	res.Code = AccessReject
+	res.SetEAPMessage(packet.Bytes())
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package goradius
+
+import (
+	"context"
+	"crypto/md5"
+	"testing"
+)
+
+// eapPeer drives an EAPServer the way a NAS relaying a peer would, keeping
+// the State of the last Access-Challenge.
+type eapPeer struct {
+	t       *testing.T
+	handler Handler
+	state   []byte
+}
+
+func newEAPPeer(t *testing.T, e *EAPServer, next Handler) *eapPeer {
+	return &eapPeer{t: t, handler: e.Middleware()(next)}
+}
+
+// send relays msg and returns the reply and the EAP packet it carries.
+func (p *eapPeer) send(msg []byte) (*RadiusPacket, *EAPPacket) {
+
+	p.t.Helper()
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.AddAttributeByType(EAPMessage, msg)
+	if p.state != nil {
+		req.AddAttributeByType(State, p.state)
+	}
+
+	w := &responseWriter{response: NewRadiusPacket()}
+	if err := p.handler.ServeRADIUS(context.Background(), w, req); err != nil {
+		p.t.Fatal(err)
+	}
+
+	res := w.Response()
+	p.state = res.GetFirstAttribute("State")
+
+	packet, err := ParseEAPPacket(res.EAPMessage())
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return res, packet
+}
+
+func (p *eapPeer) respond(request *EAPPacket, methodType uint8, data []byte) (*RadiusPacket, *EAPPacket) {
+
+	response := EAPPacket{Code: EAPResponse, Identifier: request.Identifier, Type: methodType, Data: data}
+
+	return p.send(response.Bytes())
+}
+
+// closingMethod fails every response and records being closed.
+type closingMethod struct {
+	closed *int
+}
+
+func (m *closingMethod) Start(ctx context.Context, s *EAPSession) ([]byte, error) {
+	return []byte{eapTLSStart}, nil
+}
+
+func (m *closingMethod) Handle(ctx context.Context, s *EAPSession, data []byte) (uint8, []byte, error) {
+	return EAPFailure, nil, nil
+}
+
+func (m *closingMethod) Close() error {
+	*m.closed++
+	return nil
+}
+
+func eapMD5Response(request *EAPPacket, password string) []byte {
+
+	hash := md5.New()
+	hash.Write([]byte{request.Identifier})
+	hash.Write([]byte(password))
+	hash.Write(request.Data[1:])
+
+	return append([]byte{eapMD5ValueSize}, hash.Sum(nil)...)
+}
+
+func TestEAPNakToMD5(t *testing.T) {
+
+	users := PasswordSourceFunc(func(ctx context.Context, username string) ([]byte, error) {
+		if username != "steve" {
+			return nil, ErrUnknownUser
+		}
+		return []byte("testing"), nil
+	})
+
+	for _, password := range []string{"testing", "wrong"} {
+
+		closed := 0
+		e := NewEAPServer()
+		e.Register(EAPTypeTLS, func() EAPMethod { return &closingMethod{&closed} })
+		e.Register(EAPTypeMD5Challenge, EAPMD5(users))
+
+		var authorized *EAPSession
+		peer := newEAPPeer(t, e, HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+			authorized = EAPSessionFromContext(ctx)
+			return nil
+		}))
+
+		// EAP-Start
+		res, request := peer.send(nil)
+		if res.Code != AccessChallenge || request.Code != EAPRequest || request.Type != EAPTypeIdentity {
+			t.Fatalf("EAP-Start answered with %v %+v", res.Code, request)
+		}
+
+		res, request = peer.respond(request, EAPTypeIdentity, []byte("steve"))
+		if res.Code != AccessChallenge || request.Type != EAPTypeTLS {
+			t.Fatalf("Identity answered with %v %+v", res.Code, request)
+		}
+
+		res, request = peer.respond(request, EAPTypeNak, []byte{EAPTypeMD5Challenge})
+		if res.Code != AccessChallenge || request.Type != EAPTypeMD5Challenge {
+			t.Fatalf("Nak answered with %v %+v", res.Code, request)
+		}
+		if closed != 1 {
+			t.Errorf("the method given up was closed %v times", closed)
+		}
+
+		res, result := peer.respond(request, EAPTypeMD5Challenge, eapMD5Response(request, password))
+
+		if password == "testing" {
+			if res.Code != AccessAccept || result.Code != EAPSuccess {
+				t.Errorf("got %v %+v, want Access-Accept with EAP-Success", res.Code, result)
+			}
+			if authorized == nil || authorized.Identity != "steve" || authorized.MethodType() != EAPTypeMD5Challenge {
+				t.Errorf("next got session %+v", authorized)
+			}
+		} else {
+			if res.Code != AccessReject || result.Code != EAPFailure {
+				t.Errorf("got %v %+v, want Access-Reject with EAP-Failure", res.Code, result)
+			}
+			if authorized != nil {
+				t.Errorf("next called after a failure")
+			}
+		}
+		if result.Identifier != request.Identifier {
+			t.Errorf("result Identifier %v, want %v", result.Identifier, request.Identifier)
+		}
+		if peer.state != nil {
+			t.Errorf("State sent with the result")
+		}
+		if len(e.sessions.entries) != 0 {
+			t.Errorf("%v sessions left in the store", len(e.sessions.entries))
+		}
+	}
+}
+
+func TestEAPServerZeroValue(t *testing.T) {
+
+	users := PasswordSourceFunc(func(ctx context.Context, username string) ([]byte, error) {
+		return []byte("testing"), nil
+	})
+
+	e := &EAPServer{}
+	e.Register(EAPTypeMD5Challenge, EAPMD5(users))
+
+	p := newEAPPeer(t, e, HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		return nil
+	}))
+
+	_, request := p.send(nil)
+	_, request = p.respond(request, EAPTypeIdentity, []byte("steve"))
+	if request.Code != EAPRequest || request.Type != EAPTypeMD5Challenge {
+		t.Fatalf("got %+v, want an EAP-MD5 challenge", request)
+	}
+	if res, result := p.respond(request, EAPTypeMD5Challenge, eapMD5Response(request, "testing")); res.Code != AccessAccept || result.Code != EAPSuccess {
+		t.Errorf("got %v %+v, want Access-Accept with EAP-Success", res.Code, result)
+	}
+}
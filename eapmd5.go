@@ -0,0 +1,58 @@
+package goradius
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"errors"
+)
+
+const eapMD5ValueSize = 16
+
+// EAPMD5 returns the EAP-MD5 method (RFC 3748 §5.4), which checks the
+// responses against the passwords of source. It derives no keys and doesn't
+// authenticate the server, so it is only fit for wired or test networks.
+func EAPMD5(source PasswordSource) NewEAPMethod {
+	return func() EAPMethod {
+		return &eapMD5{source: source}
+	}
+}
+
+type eapMD5 struct {
+	source    PasswordSource
+	challenge []byte
+}
+
+func (m *eapMD5) Start(ctx context.Context, s *EAPSession) ([]byte, error) {
+
+	m.challenge = randomBytes(eapMD5ValueSize)
+
+	return append([]byte{eapMD5ValueSize}, m.challenge...), nil
+}
+
+func (m *eapMD5) Handle(ctx context.Context, s *EAPSession, data []byte) (uint8, []byte, error) {
+
+	if len(data) < 1+eapMD5ValueSize || data[0] != eapMD5ValueSize {
+		return EAPFailure, nil, nil
+	}
+
+	password, err := m.source.Password(ctx, s.Identity)
+	if errors.Is(err, ErrUnknownUser) {
+		return EAPFailure, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// the same as CHAP, with the Identifier of the request
+	hash := md5.New()
+	hash.Write([]byte{s.Identifier})
+	hash.Write(password)
+	hash.Write(m.challenge)
+
+	if subtle.ConstantTimeCompare(hash.Sum(nil), data[1:1+eapMD5ValueSize]) != 1 {
+		return EAPFailure, nil, nil
+	}
+
+	return EAPSuccess, nil, nil
+}
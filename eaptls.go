@@ -0,0 +1,397 @@
+package goradius
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// flags of EAP-TLS packets (RFC 5216 §3.1), EAP-TTLS and PEAP put their
+// version in the low bits
+const (
+	eapTLSLengthIncluded = 0x80
+	eapTLSMoreFragments  = 0x40
+	eapTLSStart          = 0x20
+)
+
+// eapTLSFragmentSize is the most TLS data sent in one EAP-Request.
+const eapTLSFragmentSize = 1024
+
+// eapTLSMaxMessage is the most TLS data accepted from the peer in one
+// fragmented message, well above what a certificate chain needs.
+const eapTLSMaxMessage = 64 * 1024
+
+var errTunnelClosed = errors.New("TLS tunnel closed.")
+
+// tunnelConn is the net.Conn of the TLS server of an EAP conversation. What
+// TLS writes is collected for the next EAP-Request, and reads wait for the
+// next EAP-Response.
+type tunnelConn struct {
+	in      chan []byte
+	waiting chan struct{}
+	pending []byte
+	out     []byte
+	timeout time.Duration
+}
+
+// wait tells the tunnel that TLS needs data from the peer and returns it.
+func (c *tunnelConn) wait() ([]byte, error) {
+
+	c.waiting <- struct{}{}
+
+	select {
+	case data, ok := <-c.in:
+		if !ok {
+			return nil, errTunnelClosed
+		}
+		return data, nil
+	case <-time.After(c.timeout):
+		return nil, errTunnelClosed
+	}
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+
+	for len(c.pending) == 0 {
+		data, err := c.wait()
+		if err != nil {
+			return 0, io.EOF
+		}
+		c.pending = data
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	c.out = append(c.out, b...)
+	return len(b), nil
+}
+
+func (c *tunnelConn) Close() error                       { return nil }
+func (c *tunnelConn) LocalAddr() net.Addr                { return tunnelAddr{} }
+func (c *tunnelConn) RemoteAddr() net.Addr               { return tunnelAddr{} }
+func (c *tunnelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *tunnelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type tunnelAddr struct{}
+
+func (tunnelAddr) Network() string { return "eap" }
+func (tunnelAddr) String() string  { return "eap" }
+
+// tlsTunnel runs the TLS server of a conversation in its own goroutine,
+// followed by program for the tunnelled methods. Every EAP round trip feeds
+// it the TLS data of the peer and collects what it answers.
+type tlsTunnel struct {
+	conn *tunnelConn
+	tls  *tls.Conn
+	done chan error
+	ctx  context.Context
+
+	closed bool
+}
+
+func newTLSTunnel(config *tls.Config, timeout time.Duration, program func(t *tlsTunnel) error) *tlsTunnel {
+
+	t := tlsTunnel{}
+	t.conn = &tunnelConn{
+		in:      make(chan []byte),
+		waiting: make(chan struct{}),
+		timeout: timeout,
+	}
+	t.tls = tls.Server(t.conn, config)
+	t.done = make(chan error, 1)
+
+	go func() {
+		err := t.tls.Handshake()
+		if err == nil && program != nil {
+			err = program(&t)
+		}
+		t.done <- err
+	}()
+
+	return &t
+}
+
+// feed gives data to TLS and returns what it sent back once it waits for
+// more, or finished with the result of the handshake and program.
+func (t *tlsTunnel) feed(ctx context.Context, data []byte, first bool) (out []byte, finished bool, err error) {
+
+	t.ctx = ctx
+
+	if !first {
+		select {
+		case t.conn.in <- data:
+		case err = <-t.done:
+			// timed out waiting for the peer
+			t.closed = true
+			return nil, true, err
+		}
+	}
+
+	select {
+	case <-t.conn.waiting:
+	case err = <-t.done:
+		finished = true
+		t.closed = true
+	}
+
+	out = t.conn.out
+	t.conn.out = nil
+
+	return out, finished, err
+}
+
+// close stops the goroutine of the tunnel if it is still running.
+func (t *tlsTunnel) close() {
+
+	if !t.closed {
+		close(t.conn.in)
+		t.closed = true
+	}
+}
+
+// receive returns the next application data of the peer, nil when it only
+// acknowledged what the server sent.
+func (t *tlsTunnel) receive() ([]byte, error) {
+
+	data, err := t.conn.wait()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	t.conn.pending = data
+
+	buf := make([]byte, 16384)
+	n, err := t.tls.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func (t *tlsTunnel) send(data []byte) error {
+	_, err := t.tls.Write(data)
+	return err
+}
+
+// context returns the context of the request being handled.
+func (t *tlsTunnel) context() context.Context {
+	return t.ctx
+}
+
+// tlsMethod is the part EAP-TLS, EAP-TTLS and PEAP share: fragmenting the
+// TLS data over EAP packets and running the tunnel.
+type tlsMethod struct {
+	config  *tls.Config
+	version uint8
+	label   string
+	program func(s *EAPSession, t *tlsTunnel) error
+
+	tunnel   *tlsTunnel
+	incoming []byte
+	expected int
+	outgoing []byte
+	finished bool
+	err      error
+}
+
+// tlsServerConfig clones config for EAP, which this package only supports
+// up to TLS 1.2. Sessions aren't resumed, the abbreviated handshake would
+// bring the first inner message along with the peer's Finished.
+func tlsServerConfig(config *tls.Config) *tls.Config {
+
+	config = config.Clone()
+	config.SessionTicketsDisabled = true
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		config.MaxVersion = tls.VersionTLS12
+	}
+
+	return config
+}
+
+func (m *tlsMethod) Start(ctx context.Context, s *EAPSession) ([]byte, error) {
+
+	var program func(t *tlsTunnel) error
+	if m.program != nil {
+		program = func(t *tlsTunnel) error {
+			return m.program(s, t)
+		}
+	}
+
+	m.tunnel = newTLSTunnel(m.config, s.timeout, program)
+
+	// until it waits for the ClientHello
+	_, finished, err := m.tunnel.feed(ctx, nil, true)
+	if finished {
+		return nil, err
+	}
+
+	return []byte{eapTLSStart | m.version}, nil
+}
+
+func (m *tlsMethod) Handle(ctx context.Context, s *EAPSession, data []byte) (uint8, []byte, error) {
+
+	code, next, err := m.handle(ctx, s, data)
+	if code != EAPRequest {
+		m.tunnel.close()
+	}
+
+	return code, next, err
+}
+
+func (m *tlsMethod) handle(ctx context.Context, s *EAPSession, data []byte) (uint8, []byte, error) {
+
+	if len(data) < 1 {
+		return EAPFailure, nil, nil
+	}
+
+	flags := data[0]
+	body := data[1:]
+	if flags&eapTLSLengthIncluded != 0 {
+		if len(body) < 4 {
+			return EAPFailure, nil, nil
+		}
+		// the TLS Message Length, of the whole message
+		if len(m.incoming) == 0 {
+			m.expected = int(binary.BigEndian.Uint32(body))
+		}
+		body = body[4:]
+	}
+
+	// the peer acknowledges a fragment
+	if len(m.outgoing) > 0 {
+		if len(body) > 0 {
+			return EAPFailure, nil, nil
+		}
+		return EAPRequest, m.nextFragment(nil), nil
+	}
+
+	if m.finished && len(body) == 0 {
+		return m.result(s)
+	}
+
+	m.incoming = append(m.incoming, body...)
+	if len(m.incoming) > eapTLSMaxMessage || m.expected > eapTLSMaxMessage ||
+		(m.expected > 0 && len(m.incoming) > m.expected) {
+		log.Printf("EAP peer %v sent too much TLS data.", s.Identity)
+		return EAPFailure, nil, nil
+	}
+	if flags&eapTLSMoreFragments != 0 {
+		return EAPRequest, []byte{m.version}, nil
+	}
+
+	input := m.incoming
+	m.incoming = nil
+	m.expected = 0
+
+	out, finished, err := m.tunnel.feed(ctx, input, false)
+	m.finished = finished
+	m.err = err
+
+	if len(out) > 0 && err == nil {
+		return EAPRequest, m.queue(out), nil
+	}
+
+	if finished {
+		return m.result(s)
+	}
+
+	// the peer sent something that TLS couldn't make a record of
+	return EAPFailure, nil, nil
+}
+
+// Close stops the tunnel of a conversation that ended or was abandoned.
+func (m *tlsMethod) Close() error {
+
+	if m.tunnel != nil {
+		m.tunnel.close()
+	}
+
+	return nil
+}
+
+// queue returns the Type-Data of the EAP-Request carrying out, or its first
+// fragment with the total length when it doesn't fit.
+func (m *tlsMethod) queue(out []byte) []byte {
+
+	m.outgoing = out
+
+	if len(out) <= eapTLSFragmentSize {
+		return m.nextFragment([]byte{m.version})
+	}
+
+	header := []byte{m.version | eapTLSLengthIncluded}
+	header = binary.BigEndian.AppendUint32(header, uint32(len(out)))
+
+	return m.nextFragment(header)
+}
+
+func (m *tlsMethod) nextFragment(header []byte) []byte {
+
+	if header == nil {
+		header = []byte{m.version}
+	}
+
+	chunk := m.outgoing
+	if len(chunk) > eapTLSFragmentSize {
+		chunk = chunk[:eapTLSFragmentSize]
+	}
+	m.outgoing = m.outgoing[len(chunk):]
+
+	if len(m.outgoing) > 0 {
+		header[0] |= eapTLSMoreFragments
+	}
+
+	return append(header, chunk...)
+}
+
+// result ends the method once the tunnel finished, deriving the keys on
+// success.
+func (m *tlsMethod) result(s *EAPSession) (uint8, []byte, error) {
+
+	if m.err != nil {
+		return EAPFailure, nil, nil
+	}
+
+	// TLS 1.2 peers without Extended Master Secret can't have keys
+	state := m.tunnel.tls.ConnectionState()
+	keys, err := state.ExportKeyingMaterial(m.label, nil, 128)
+	if err != nil {
+		log.Printf("No keys for EAP peer %v: %v", s.Identity, err)
+		return EAPFailure, nil, nil
+	}
+
+	s.MSK = keys[:64]
+	s.EMSK = keys[64:]
+	s.TLS = &state
+
+	return EAPSuccess, nil, nil
+}
+
+// EAPTLS returns the EAP-TLS method (RFC 5216), peers authenticate with a
+// certificate verified with config, which requires one whatever its
+// ClientAuth says.
+func EAPTLS(config *tls.Config) NewEAPMethod {
+
+	config = tlsServerConfig(config)
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return func() EAPMethod {
+		return &tlsMethod{config: config, label: "client EAP encryption"}
+	}
+}
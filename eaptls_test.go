@@ -0,0 +1,464 @@
+package goradius
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tlsPeer is a software supplicant for the TLS based methods: a crypto/tls
+// client fed through a tunnelConn like the server, running program once
+// the handshake is over.
+type tlsPeer struct {
+	config  *tls.Config
+	program func(t *tlsTunnel) error
+
+	tunnel    *tlsTunnel
+	frag      tlsMethod
+	incoming  []byte
+	fragments int
+	finished  bool
+	err       error
+}
+
+func newPeerTunnel(config *tls.Config, program func(t *tlsTunnel) error) *tlsTunnel {
+
+	t := tlsTunnel{}
+	t.conn = &tunnelConn{
+		in:      make(chan []byte),
+		waiting: make(chan struct{}),
+		timeout: 5 * time.Second,
+	}
+	t.tls = tls.Client(t.conn, config)
+	t.done = make(chan error, 1)
+
+	go func() {
+		err := t.tls.Handshake()
+		if err == nil && program != nil {
+			err = program(&t)
+		}
+		t.done <- err
+	}()
+
+	return &t
+}
+
+// handle returns the Type-Data answering the Type-Data of a request.
+func (p *tlsPeer) handle(data []byte) []byte {
+
+	flags := data[0]
+	body := data[1:]
+	if flags&eapTLSLengthIncluded != 0 {
+		body = body[4:]
+	}
+
+	if flags&eapTLSStart != 0 {
+		p.tunnel = newPeerTunnel(p.config, p.program)
+		return p.feed(nil, true)
+	}
+
+	// the server acknowledges a fragment
+	if len(p.frag.outgoing) > 0 {
+		return p.frag.nextFragment(nil)
+	}
+
+	p.incoming = append(p.incoming, body...)
+	if flags&eapTLSMoreFragments != 0 {
+		p.fragments++
+		return []byte{0}
+	}
+
+	input := p.incoming
+	p.incoming = nil
+
+	return p.feed(input, false)
+}
+
+func (p *tlsPeer) feed(input []byte, first bool) []byte {
+
+	if p.finished {
+		return []byte{0}
+	}
+
+	out, finished, err := p.tunnel.feed(context.Background(), input, first)
+	if finished {
+		p.finished, p.err = true, err
+	}
+
+	if len(out) == 0 {
+		return []byte{0}
+	}
+
+	return p.frag.queue(out)
+}
+
+func (p *tlsPeer) close() {
+
+	if p.tunnel != nil {
+		p.tunnel.close()
+	}
+}
+
+// keys returns the keying material the peer derived with label.
+func (p *tlsPeer) keys(label string) []byte {
+
+	state := p.tunnel.tls.ConnectionState()
+	keys, _ := state.ExportKeyingMaterial(label, nil, 128)
+
+	return keys
+}
+
+// runTLSMethod authenticates peer with methodType, and returns the final
+// reply and the session that reached the handler after the EAP server.
+func runTLSMethod(t *testing.T, e *EAPServer, methodType uint8, peer *tlsPeer) (*RadiusPacket, *EAPPacket, *EAPSession) {
+
+	t.Helper()
+
+	var session *EAPSession
+	eap := newEAPPeer(t, e, HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		session = EAPSessionFromContext(ctx)
+		return nil
+	}))
+
+	_, request := eap.send(nil)
+	res, request := eap.respond(request, EAPTypeIdentity, []byte("anonymous"))
+	if request.Code == EAPRequest && request.Type != methodType {
+		res, request = eap.respond(request, EAPTypeNak, []byte{methodType})
+	}
+
+	for rounds := 0; request.Code == EAPRequest; rounds++ {
+		if rounds > 50 {
+			t.Fatalf("no result after %v rounds", rounds)
+		}
+		res, request = eap.respond(request, methodType, peer.handle(request.Data))
+	}
+
+	if len(e.sessions.entries) != 0 {
+		t.Errorf("%v sessions left in the store", len(e.sessions.entries))
+	}
+
+	return res, request, session
+}
+
+func testUsers() NTHashSource {
+	return NTHashSourceFunc(func(ctx context.Context, username string) ([16]byte, error) {
+		if username != "steve" {
+			return [16]byte{}, ErrUnknownUser
+		}
+		return NTPasswordHash("testing"), nil
+	})
+}
+
+func testTLSConfigs(t *testing.T) (server, client, anonymous *tls.Config) {
+
+	pki := newTestPKI(t, "radius.example.com", "steve")
+
+	server = &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientCAs: pki.pool}
+	anonymous = &tls.Config{RootCAs: pki.pool, ServerName: "radius.example.com"}
+	client = anonymous.Clone()
+	client.Certificates = []tls.Certificate{pki.client}
+
+	return server, client, anonymous
+}
+
+func checkAccepted(t *testing.T, name string, res *RadiusPacket, result *EAPPacket, peer *tlsPeer, label string) {
+
+	t.Helper()
+
+	if res.Code != AccessAccept || result.Code != EAPSuccess {
+		t.Fatalf("%v: got %v %+v, want Access-Accept with EAP-Success (peer: %v)", name, res.Code, result, peer.err)
+	}
+	if peer.err != nil {
+		t.Errorf("%v: peer failed: %v", name, peer.err)
+	}
+
+	keys := peer.keys(label)
+	recv := firstValue(res, microsoftAttribute(msMPPERecvKey))
+	send := firstValue(res, microsoftAttribute(msMPPESendKey))
+	if !bytes.Equal(recv, keys[:32]) || !bytes.Equal(send, keys[32:64]) {
+		t.Errorf("%v: MS-MPPE keys don't match the MSK of the peer", name)
+	}
+}
+
+func checkRejected(t *testing.T, name string, res *RadiusPacket, result *EAPPacket, session *EAPSession) {
+
+	t.Helper()
+
+	if res.Code != AccessReject || result.Code != EAPFailure {
+		t.Errorf("%v: got %v %+v, want Access-Reject with EAP-Failure", name, res.Code, result)
+	}
+	if session != nil {
+		t.Errorf("%v: handler called after a failure", name)
+	}
+}
+
+func TestEAPTLS(t *testing.T) {
+
+	server, client, anonymous := testTLSConfigs(t)
+
+	e := NewEAPServer()
+	e.Register(EAPTypeTLS, EAPTLS(server))
+
+	peer := &tlsPeer{config: client}
+	defer peer.close()
+	res, result, session := runTLSMethod(t, e, EAPTypeTLS, peer)
+	checkAccepted(t, "EAP-TLS", res, result, peer, "client EAP encryption")
+
+	if session == nil || session.TLS == nil || len(session.TLS.PeerCertificates) == 0 ||
+		session.TLS.PeerCertificates[0].Subject.CommonName != "steve" {
+		t.Errorf("session doesn't have the certificate of the peer")
+	}
+	if len(session.EMSK) != 64 {
+		t.Errorf("got %v octets of EMSK", len(session.EMSK))
+	}
+	if peer.fragments == 0 {
+		t.Errorf("the server flight wasn't fragmented")
+	}
+
+	noCert := &tlsPeer{config: anonymous}
+	defer noCert.close()
+	res, result, session = runTLSMethod(t, e, EAPTypeTLS, noCert)
+	checkRejected(t, "EAP-TLS without certificate", res, result, session)
+}
+
+// Without Extended Master Secret, or here with renegotiation allowed,
+// crypto/tls can't export keys. The peer must be told.
+func TestEAPTLSWithoutKeys(t *testing.T) {
+
+	server, client, _ := testTLSConfigs(t)
+	server.Renegotiation = tls.RenegotiateOnceAsClient
+
+	e := NewEAPServer()
+	e.Register(EAPTypeTLS, EAPTLS(server))
+
+	peer := &tlsPeer{config: client}
+	defer peer.close()
+	res, result, session := runTLSMethod(t, e, EAPTypeTLS, peer)
+	checkRejected(t, "EAP-TLS without keys", res, result, session)
+}
+
+func ttlsPAP(password string) func(t *tlsTunnel) error {
+	return func(t *tlsTunnel) error {
+		avps := appendAVP(nil, 0, uint32(UserName), []byte("steve"))
+		avps = appendAVP(avps, 0, uint32(UserPassword), append([]byte(password), 0, 0))
+		return t.send(avps)
+	}
+}
+
+func ttlsMSCHAPv2(password string) func(t *tlsTunnel) error {
+	return func(t *tlsTunnel) error {
+
+		state := t.tls.ConnectionState()
+		challenge, err := state.ExportKeyingMaterial("ttls challenge", nil, msCHAPv2ChallengeSize+1)
+		if err != nil {
+			return err
+		}
+		ident := challenge[msCHAPv2ChallengeSize]
+		challenge = challenge[:msCHAPv2ChallengeSize]
+
+		peerChallenge := randomBytes(16)
+		ntHash := NTPasswordHash(password)
+		ntResponse := challengeResponse(challengeHash(peerChallenge, challenge, "steve"), ntHash)
+
+		response := append([]byte{ident, 0}, peerChallenge...)
+		response = append(response, make([]byte, 8)...)
+		response = append(response, ntResponse...)
+
+		avps := appendAVP(nil, 0, uint32(UserName), []byte("steve"))
+		avps = appendAVP(avps, VendorMicrosoft, msCHAPChallenge, challenge)
+		avps = appendAVP(avps, VendorMicrosoft, msCHAP2Response, response)
+		if err := t.send(avps); err != nil {
+			return err
+		}
+
+		data, err := t.receive()
+		if err != nil {
+			return err
+		}
+		answer, err := parseAVPs(data)
+		if err != nil {
+			return err
+		}
+
+		want := string([]byte{ident}) + authenticatorResponse(ntHash, ntResponse, challengeHash(peerChallenge, challenge, "steve"))
+		if string(findAVP(answer, VendorMicrosoft, msCHAP2Success)) != want {
+			return errors.New("server didn't prove it knows the password")
+		}
+
+		return nil
+	}
+}
+
+func TestEAPTTLS(t *testing.T) {
+
+	server, _, anonymous := testTLSConfigs(t)
+
+	e := NewEAPServer()
+	e.Register(EAPTypeTTLS, EAPTTLS(server, testUsers()))
+
+	tests := []struct {
+		name    string
+		program func(t *tlsTunnel) error
+		ok      bool
+	}{
+		{"PAP", ttlsPAP("testing"), true},
+		{"PAP wrong password", ttlsPAP("wrong"), false},
+		{"MS-CHAPv2", ttlsMSCHAPv2("testing"), true},
+		{"MS-CHAPv2 wrong password", ttlsMSCHAPv2("wrong"), false},
+	}
+
+	for _, tt := range tests {
+
+		peer := &tlsPeer{config: anonymous, program: tt.program}
+		res, result, session := runTLSMethod(t, e, EAPTypeTTLS, peer)
+		peer.close()
+
+		if !tt.ok {
+			checkRejected(t, tt.name, res, result, session)
+			continue
+		}
+
+		checkAccepted(t, tt.name, res, result, peer, "ttls keying material")
+		if session == nil || session.Identity != "steve" {
+			t.Errorf("%v: session doesn't have the inner identity", tt.name)
+		}
+	}
+}
+
+// peapMSCHAPv2 answers the inner EAP-MSCHAPv2 of PEAPv0, whose requests and
+// responses come without EAP header.
+func peapMSCHAPv2(password string) func(t *tlsTunnel) error {
+	return func(t *tlsTunnel) error {
+
+		request, err := t.receive()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(request, []byte{EAPTypeIdentity}) {
+			return errors.New("expected an Identity request")
+		}
+		if err := t.send(append([]byte{EAPTypeIdentity}, "steve"...)); err != nil {
+			return err
+		}
+
+		request, err = t.receive()
+		if err != nil {
+			return err
+		}
+		if len(request) < 22 || request[0] != EAPTypeMSCHAPv2 || request[1] != eapMSCHAPv2Challenge {
+			return errors.New("expected an MS-CHAPv2 challenge")
+		}
+		id := request[2]
+		challenge := request[6:22]
+
+		peerChallenge := randomBytes(16)
+		ntResponse := challengeResponse(challengeHash(peerChallenge, challenge, "steve"), NTPasswordHash(password))
+
+		value := append([]byte{49}, peerChallenge...)
+		value = append(value, make([]byte, 8)...)
+		value = append(value, ntResponse...)
+		value = append(value, 0)
+		value = append(value, "steve"...)
+		if err := t.send(eapMSCHAPv2Packet(eapMSCHAPv2Response, id, value)); err != nil {
+			return err
+		}
+
+		request, err = t.receive()
+		if err != nil {
+			return err
+		}
+		if len(request) < 2 || request[1] != eapMSCHAPv2Success {
+			return errors.New("expected an MS-CHAPv2 success")
+		}
+		if err := t.send([]byte{EAPTypeMSCHAPv2, eapMSCHAPv2Success}); err != nil {
+			return err
+		}
+
+		// the Result TLV comes with its EAP header
+		request, err = t.receive()
+		if err != nil {
+			return err
+		}
+		tlv, err := ParseEAPPacket(request)
+		if err != nil || tlv.Type != eapTypeTLV || !bytes.Equal(tlv.Data, peapResultSuccess) {
+			return errors.New("expected a success Result TLV")
+		}
+		result := EAPPacket{Code: EAPResponse, Identifier: tlv.Identifier, Type: eapTypeTLV, Data: tlv.Data}
+
+		return t.send(result.Bytes())
+	}
+}
+
+func TestPEAP(t *testing.T) {
+
+	server, _, anonymous := testTLSConfigs(t)
+
+	e := NewEAPServer()
+	e.Register(EAPTypePEAP, PEAP(server, testUsers()))
+
+	peer := &tlsPeer{config: anonymous, program: peapMSCHAPv2("testing")}
+	defer peer.close()
+	res, result, session := runTLSMethod(t, e, EAPTypePEAP, peer)
+	checkAccepted(t, "PEAP", res, result, peer, "client EAP encryption")
+	if session == nil || session.Identity != "steve" {
+		t.Errorf("session doesn't have the inner identity")
+	}
+
+	wrong := &tlsPeer{config: anonymous, program: peapMSCHAPv2("wrong")}
+	defer wrong.close()
+	res, result, session = runTLSMethod(t, e, EAPTypePEAP, wrong)
+	checkRejected(t, "PEAP wrong password", res, result, session)
+}
+
+func TestEAPTLSReassemblyLimit(t *testing.T) {
+
+	server, _, _ := testTLSConfigs(t)
+
+	start := func() (*tlsMethod, *EAPSession) {
+		s := &EAPSession{timeout: time.Second}
+		m := EAPTLS(server)().(*tlsMethod)
+		if _, err := m.Start(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+		return m, s
+	}
+
+	fragment := func(flags uint8, length int, size int) []byte {
+		b := []byte{flags}
+		if flags&eapTLSLengthIncluded != 0 {
+			b = binary.BigEndian.AppendUint32(b, uint32(length))
+		}
+		return append(b, make([]byte, size)...)
+	}
+
+	// more than announced
+	m, s := start()
+	if code, _, _ := m.Handle(context.Background(), s, fragment(eapTLSLengthIncluded|eapTLSMoreFragments, 100, 60)); code != EAPRequest {
+		t.Errorf("first fragment answered with %v", code)
+	}
+	if code, _, _ := m.Handle(context.Background(), s, fragment(eapTLSMoreFragments, 0, 60)); code != EAPFailure {
+		t.Errorf("data past the TLS Message Length answered with %v", code)
+	}
+
+	// more than anything reasonable announced
+	m, s = start()
+	if code, _, _ := m.Handle(context.Background(), s, fragment(eapTLSLengthIncluded|eapTLSMoreFragments, 1<<30, 60)); code != EAPFailure {
+		t.Errorf("huge TLS Message Length answered with %v", code)
+	}
+
+	// nothing announced, fragments that never end
+	m, s = start()
+	for i := 0; ; i++ {
+		code, _, _ := m.Handle(context.Background(), s, fragment(eapTLSMoreFragments, 0, 1000))
+		if code == EAPFailure {
+			break
+		}
+		if i > eapTLSMaxMessage/1000 {
+			t.Fatalf("still reassembling after %v fragments", i)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package goradius
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	avpVendorFlag    = 0x80
+	avpMandatoryFlag = 0x40
+	avpHeaderLength  = 8
+)
+
+var errInnerAuthentication = errors.New("Inner authentication failed.")
+
+// diameterAVP is an attribute carried in the EAP-TTLS tunnel, in the
+// Diameter format (RFC 5281 §10). RADIUS attributes keep their numbers.
+type diameterAVP struct {
+	vendorId uint32
+	code     uint32
+	data     []byte
+}
+
+func parseAVPs(b []byte) ([]diameterAVP, error) {
+
+	var avps []diameterAVP
+
+	for len(b) > 0 {
+
+		if len(b) < avpHeaderLength {
+			return nil, ErrBadAttributeLength
+		}
+
+		avp := diameterAVP{code: binary.BigEndian.Uint32(b[:4])}
+		flags := b[4]
+		length := int(readUint(b[5:8]))
+
+		header := avpHeaderLength
+		if flags&avpVendorFlag != 0 {
+			header += 4
+		}
+		if length < header || length > len(b) {
+			return nil, ErrBadAttributeLength
+		}
+		if flags&avpVendorFlag != 0 {
+			avp.vendorId = binary.BigEndian.Uint32(b[8:12])
+		}
+		avp.data = b[header:length]
+		avps = append(avps, avp)
+
+		// padded to 4 octets
+		length = (length + 3) &^ 3
+		if length > len(b) {
+			length = len(b)
+		}
+		b = b[length:]
+	}
+
+	return avps, nil
+}
+
+func appendAVP(b []byte, vendorId, code uint32, data []byte) []byte {
+
+	length := avpHeaderLength + len(data)
+	flags := uint8(avpMandatoryFlag)
+	if vendorId != 0 {
+		length += 4
+		flags |= avpVendorFlag
+	}
+
+	b = binary.BigEndian.AppendUint32(b, code)
+	b = append(b, flags)
+	b = appendUint(b, uint32(length), 3)
+	if vendorId != 0 {
+		b = binary.BigEndian.AppendUint32(b, vendorId)
+	}
+	b = append(b, data...)
+
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+func findAVP(avps []diameterAVP, vendorId, code uint32) []byte {
+
+	for _, avp := range avps {
+		if avp.vendorId == vendorId && avp.code == code {
+			return avp.data
+		}
+	}
+
+	return nil
+}
+
+// EAPTTLS returns the EAP-TTLS method (RFC 5281), in which peers
+// authenticate with PAP or MS-CHAPv2 inside a tunnel set up with config.
+// Passwords are checked against the NT hashes of source.
+func EAPTTLS(config *tls.Config, source NTHashSource) NewEAPMethod {
+
+	config = tlsServerConfig(config)
+
+	return func() EAPMethod {
+		return &tlsMethod{
+			config:  config,
+			label:   "ttls keying material",
+			program: ttlsProgram(source),
+		}
+	}
+}
+
+func ttlsProgram(source NTHashSource) func(s *EAPSession, t *tlsTunnel) error {
+	return func(s *EAPSession, t *tlsTunnel) error {
+
+		data, err := t.receive()
+		if err != nil {
+			return err
+		}
+
+		avps, err := parseAVPs(data)
+		if err != nil {
+			return err
+		}
+
+		username := string(findAVP(avps, 0, uint32(UserName)))
+		ntHash, err := source.NTHash(t.context(), username)
+		if errors.Is(err, ErrUnknownUser) {
+			return errInnerAuthentication
+		}
+		if err != nil {
+			return err
+		}
+
+		if password := findAVP(avps, 0, uint32(UserPassword)); password != nil {
+			// PAP, the password may come NUL padded
+			hash := NTPasswordHash(string(bytes.TrimRight(password, "\x00")))
+			if subtle.ConstantTimeCompare(hash[:], ntHash[:]) != 1 {
+				return errInnerAuthentication
+			}
+			s.Identity = username
+			return nil
+		}
+
+		response := findAVP(avps, VendorMicrosoft, msCHAP2Response)
+		challenge := findAVP(avps, VendorMicrosoft, msCHAPChallenge)
+		if response == nil || challenge == nil {
+			return errors.New("No supported inner authentication in EAP-TTLS.")
+		}
+
+		// the challenge comes from the tunnel, so it can't be replayed
+		// (RFC 5281 §11.1)
+		state := t.tls.ConnectionState()
+		expected, err := state.ExportKeyingMaterial("ttls challenge", nil, msCHAPv2ChallengeSize+1)
+		if err != nil {
+			return err
+		}
+
+		if len(response) != msCHAPResponseLength || !bytes.Equal(challenge, expected[:msCHAPv2ChallengeSize]) ||
+			response[0] != expected[msCHAPv2ChallengeSize] {
+			return errInnerAuthentication
+		}
+
+		authenticator, ok := checkMSCHAPv2(challenge, response[2:18], response[26:50], username, ntHash)
+		if !ok {
+			return errInnerAuthentication
+		}
+		s.Identity = username
+
+		success := append([]byte{response[0]}, authenticator...)
+
+		return t.send(appendAVP(nil, VendorMicrosoft, msCHAP2Success, success))
+	}
+}
@@ -0,0 +1,321 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// RFC 6929 attribute types
+const (
+	ExtendedAttribute1 = uint8(241)
+	ExtendedAttribute2 = uint8(242)
+	ExtendedAttribute3 = uint8(243)
+	ExtendedAttribute4 = uint8(244)
+	ExtendedAttribute5 = uint8(245) // Long Extended Type
+	ExtendedAttribute6 = uint8(246) // Long Extended Type
+
+	// ExtendedVendorSpecific is the Extended-Type of the vendor attributes
+	// carried in extended attributes.
+	ExtendedVendorSpecific = uint8(26)
+)
+
+// flag of Long Extended Type attributes that is set on every fragment but
+// the last one
+const longExtendedMore = 0x80
+
+const maxAttributeValue = 253
+
+// AttributeID identifies an attribute the way the dictionary numbers them:
+// the vendor, 0 for standard attributes, and the attribute numbers from the
+// outermost one down through extended types and TLVs. e.g. {0, [241 1]}
+// for an extended attribute or {9, [1]} for Cisco-AVPair.
+type AttributeID struct {
+	VendorId uint32
+	OID      []uint32
+}
+
+func (id AttributeID) Equal(other AttributeID) bool {
+
+	if id.VendorId != other.VendorId || len(id.OID) != len(other.OID) {
+		return false
+	}
+
+	for i := range id.OID {
+		if id.OID[i] != other.OID[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns the name FreeRADIUS gives to unknown attributes, e.g.
+// Attr-241.1 or Vendor-9-Attr-1.
+func (id AttributeID) String() string {
+
+	if id.VendorId == 0 {
+		return "Attr-" + oidString(id.OID)
+	}
+
+	return fmt.Sprintf("Vendor-%v-Attr-%v", id.VendorId, oidString(id.OID))
+}
+
+// ID returns the identifier of the attribute, as found in the dictionary.
+func (a *DictAttribute) ID() AttributeID {
+	return AttributeID{a.VendorId(), a.OID}
+}
+
+// ID returns the identifier of the attribute.
+func (r RadiusAttribute) ID() AttributeID {
+
+	if r.VendorId != 0 {
+		return AttributeID{r.VendorId, append([]uint32{r.VendorType}, r.Path...)}
+	}
+
+	return AttributeID{0, append([]uint32{uint32(r.Type)}, r.Path...)}
+}
+
+func isExtended(t uint8) bool {
+	return t >= ExtendedAttribute1 && t <= ExtendedAttribute4
+}
+
+func isLongExtended(t uint8) bool {
+	return t == ExtendedAttribute5 || t == ExtendedAttribute6
+}
+
+// NewAttribute returns the attribute id with value. Vendor attributes go in
+// a Vendor-Specific, or in the Extended-Vendor-Specific the dictionary gives
+// for their vendor.
+func (d *Dictionary) NewAttribute(id AttributeID, value []byte) (RadiusAttribute, error) {
+
+	if len(id.OID) == 0 {
+		return RadiusAttribute{}, errors.New("Empty attribute identifier.")
+	}
+
+	// the type of vendor attributes can be wider, see encodeVendorSpecific
+	for i, n := range id.OID {
+		if n > 255 && (i > 0 || id.VendorId == 0) {
+			return RadiusAttribute{}, fmt.Errorf("Invalid attribute identifier %v.", id)
+		}
+	}
+
+	attr := RadiusAttribute{Value: value}
+
+	if len(id.OID) > 1 {
+		attr.Path = append([]uint32{}, id.OID[1:]...)
+	}
+
+	if id.VendorId == 0 {
+		attr.Type = uint8(id.OID[0])
+		if (isExtended(attr.Type) || isLongExtended(attr.Type)) && len(attr.Path) == 0 {
+			return RadiusAttribute{}, fmt.Errorf("%v is missing its Extended-Type.", id)
+		}
+		return attr, nil
+	}
+
+	attr.Type = VendorSpecific
+	attr.VendorId = id.VendorId
+	attr.VendorType = id.OID[0]
+
+	if vendor, ok := d.FindVendorById(id.VendorId); ok && vendor.Extended != 0 {
+		if attr.VendorType > 255 {
+			return RadiusAttribute{}, fmt.Errorf("Invalid attribute identifier %v.", id)
+		}
+		attr.Type = vendor.Extended
+	}
+
+	return attr, nil
+}
+
+// tlvPath returns the numbers of the TLVs nested in the attribute that goes
+// on the wire.
+func (r RadiusAttribute) tlvPath() []uint32 {
+
+	if r.VendorId == 0 && (isExtended(r.Type) || isLongExtended(r.Type)) && len(r.Path) > 0 {
+		return r.Path[1:]
+	}
+
+	return r.Path
+}
+
+// outer returns r without its TLV numbers, the attribute that goes on the
+// wire.
+func (r RadiusAttribute) outer() RadiusAttribute {
+
+	r.Path = r.Path[:len(r.Path)-len(r.tlvPath())]
+	if len(r.Path) == 0 {
+		r.Path = nil
+	}
+
+	return r
+}
+
+func (r RadiusAttribute) sameOuter(other RadiusAttribute) bool {
+	return r.Type == other.Type && r.outer().ID().Equal(other.outer().ID())
+}
+
+type tlvLeaf struct {
+	path  []uint32
+	value []byte
+}
+
+// encodeTLVs nests leaves that share their first numbers in a single TLV.
+func encodeTLVs(leaves []tlvLeaf) ([]byte, error) {
+
+	var out []byte
+
+	for i := 0; i < len(leaves); {
+
+		number := leaves[i].path[0]
+		value := leaves[i].value
+		next := i + 1
+
+		if len(leaves[i].path) > 1 {
+
+			var children []tlvLeaf
+			for next = i; next < len(leaves); next++ {
+				leaf := leaves[next]
+				if len(leaf.path) < 2 || leaf.path[0] != number {
+					break
+				}
+				children = append(children, tlvLeaf{leaf.path[1:], leaf.value})
+			}
+
+			var err error
+			value, err = encodeTLVs(children)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(value) > maxAttributeValue {
+			return nil, fmt.Errorf("TLV %v too long.", number)
+		}
+
+		out = append(out, uint8(number), uint8(len(value)+2))
+		out = append(out, value...)
+		i = next
+	}
+
+	return out, nil
+}
+
+// decodeTLVs splits value into TLVs, it fails if the lengths don't add up.
+func decodeTLVs(value []byte) ([]tlvLeaf, error) {
+
+	var tlvs []tlvLeaf
+
+	for len(value) > 0 {
+
+		if len(value) < 2 || int(value[1]) < 2 || int(value[1]) > len(value) {
+			return nil, errors.New("Invalid TLV length.")
+		}
+
+		length := int(value[1])
+		tlvs = append(tlvs, tlvLeaf{[]uint32{uint32(value[0])}, value[2:length]})
+		value = value[length:]
+	}
+
+	return tlvs, nil
+}
+
+// expandTLVs returns the leaves of attr when the dictionary says it is a
+// tlv, or attr itself. Values that aren't valid TLVs are kept whole.
+func (d *Dictionary) expandTLVs(attr RadiusAttribute) []RadiusAttribute {
+
+	def, ok := d.attributeOf(attr)
+	if !ok || def.Type != TypeTLV {
+		return []RadiusAttribute{attr}
+	}
+
+	tlvs, err := decodeTLVs(attr.Value)
+	if err != nil {
+		return []RadiusAttribute{attr}
+	}
+
+	var leaves []RadiusAttribute
+	for _, tlv := range tlvs {
+		child := attr
+		child.Path = append(append([]uint32{}, attr.Path...), tlv.path...)
+		child.Value = tlv.value
+		leaves = append(leaves, d.expandTLVs(child)...)
+	}
+
+	return leaves
+}
+
+// decodeExtended fills the Extended-Type, and the vendor of
+// Extended-Vendor-Specific attributes, from the value of an Extended Type
+// attribute whose header was already taken off.
+func decodeExtended(attr RadiusAttribute, extendedType uint8, data []byte) (RadiusAttribute, error) {
+
+	if extendedType != ExtendedVendorSpecific {
+		attr.Path = []uint32{uint32(extendedType)}
+		attr.Value = data
+		return attr, nil
+	}
+
+	if len(data) < 5 {
+		return attr, errors.New("Extended-Vendor-Specific attribute too short.")
+	}
+
+	attr.VendorId = binary.BigEndian.Uint32(data[:4])
+	attr.VendorType = uint32(data[4])
+	attr.Value = data[5:]
+
+	return attr, nil
+}
+
+// encodeExtended returns the wire format of an Extended Type or Long
+// Extended Type attribute, fragmented when needed.
+func encodeExtended(attr RadiusAttribute) ([]byte, error) {
+
+	var extendedType uint8
+	var data []byte
+
+	if attr.VendorId != 0 {
+		extendedType = ExtendedVendorSpecific
+		data = make([]byte, 5, 5+len(attr.Value))
+		binary.BigEndian.PutUint32(data, attr.VendorId)
+		data[4] = uint8(attr.VendorType)
+	} else if len(attr.Path) > 0 {
+		extendedType = uint8(attr.Path[0])
+	} else {
+		return nil, fmt.Errorf("Attribute %v is missing its Extended-Type.", attr.Type)
+	}
+	data = append(data, attr.Value...)
+
+	if isExtended(attr.Type) {
+
+		if len(data)+1 > maxAttributeValue {
+			return nil, fmt.Errorf("Value of %v too long.", attr.ID())
+		}
+
+		out := []byte{attr.Type, uint8(len(data) + 3), extendedType}
+		return append(out, data...), nil
+	}
+
+	// Long Extended Type, RFC 6929 §2.2
+	var out []byte
+	for {
+
+		chunk := data
+		if len(chunk) > maxAttributeValue-2 {
+			chunk = chunk[:maxAttributeValue-2]
+		}
+		data = data[len(chunk):]
+
+		var flags uint8
+		if len(data) > 0 {
+			flags = longExtendedMore
+		}
+
+		out = append(out, attr.Type, uint8(len(chunk)+4), extendedType, flags)
+		out = append(out, chunk...)
+
+		if len(data) == 0 {
+			return out, nil
+		}
+	}
+}
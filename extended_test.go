@@ -0,0 +1,181 @@
+package goradius
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func extendedDictionary(t *testing.T) *Dictionary {
+
+	t.Helper()
+
+	fsys := fstest.MapFS{"dictionary": {Data: []byte(`
+ATTRIBUTE	Test-Short		241.1	string
+ATTRIBUTE	Test-Long		245.1	octets
+ATTRIBUTE	Test-TLV		241.2	tlv
+BEGIN-TLV	Test-TLV
+ATTRIBUTE	Test-TLV-Number		1	integer
+ATTRIBUTE	Test-TLV-Inner		2	tlv
+BEGIN-TLV	Test-TLV-Inner
+ATTRIBUTE	Test-TLV-Name		1	string
+END-TLV		Test-TLV-Inner
+END-TLV		Test-TLV
+VENDOR		Acme			9999
+BEGIN-VENDOR	Acme	format=Extended-Vendor-Specific-5
+ATTRIBUTE	Acme-Blob		1	octets
+END-VENDOR	Acme
+`)}}
+
+	d := NewDictionary()
+	if err := d.LoadFS(fsys, "dictionary"); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// rawAttributes splits the attributes of an encoded packet.
+func rawAttributes(t *testing.T, raw []byte) [][]byte {
+
+	t.Helper()
+
+	var attrs [][]byte
+	for rest := raw[headerEnd:]; len(rest) > 0; rest = rest[rest[1]:] {
+		if len(rest) < 2 || int(rest[1]) < 2 || int(rest[1]) > len(rest) {
+			t.Fatalf("invalid attribute in %x", raw)
+		}
+		attrs = append(attrs, rest[:rest[1]])
+	}
+
+	return attrs
+}
+
+// roundTrip encodes an Access-Request of d with attrs and parses it back.
+func roundTrip(t *testing.T, d *Dictionary, attrs map[*AttributeID][]byte, order ...*AttributeID) ([]byte, *RadiusPacket) {
+
+	t.Helper()
+
+	p := d.NewPacket()
+	p.Code = AccessRequest
+	for _, id := range order {
+		if err := p.AddAttributeByID(*id, attrs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := EncodeAndSign(p, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := d.ParsePacket(raw, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range order {
+		if values := parsed.GetAttributeByID(*id); len(values) != 1 || !bytes.Equal(values[0], attrs[id]) {
+			t.Errorf("got %x for %v, want %x", values, id, attrs[id])
+		}
+	}
+
+	return raw, parsed
+}
+
+// A Long Extended Type value is split over several attributes, each with
+// the More flag but the last one (RFC 6929 §2.2).
+func TestLongExtendedFragments(t *testing.T) {
+
+	d := extendedDictionary(t)
+	long := &AttributeID{0, []uint32{245, 1}}
+	value := bytes.Repeat([]byte("0123456789"), 60)
+
+	raw, _ := roundTrip(t, d, map[*AttributeID][]byte{long: value}, long)
+
+	attrs := rawAttributes(t, raw)
+	if len(attrs) != 3 {
+		t.Fatalf("got %v attributes, want 3", len(attrs))
+	}
+
+	var joined []byte
+	for i, attr := range attrs {
+		more := i < len(attrs)-1
+		if attr[0] != 245 || attr[2] != 1 || (attr[3] == longExtendedMore) != more {
+			t.Errorf("fragment %v starts with %x", i, attr[:4])
+		}
+		if more && len(attr) != 255 {
+			t.Errorf("fragment %v is %v octets, want 255", i, len(attr))
+		}
+		joined = append(joined, attr[4:]...)
+	}
+	if !bytes.Equal(joined, value) {
+		t.Errorf("fragments don't add up to the value")
+	}
+}
+
+// Extended-Vendor-Specific attributes carry the vendor and its type in the
+// value, only in the first fragment when they are long (RFC 6929 §2.4).
+func TestExtendedVendorSpecific(t *testing.T) {
+
+	d := extendedDictionary(t)
+	blob := &AttributeID{9999, []uint32{1}}
+	value := bytes.Repeat([]byte{0xab}, 300)
+
+	raw, parsed := roundTrip(t, d, map[*AttributeID][]byte{blob: value}, blob)
+
+	attrs := rawAttributes(t, raw)
+	if len(attrs) != 2 {
+		t.Fatalf("got %v attributes, want 2", len(attrs))
+	}
+	want := []byte{245, 255, ExtendedVendorSpecific, longExtendedMore, 0, 0, 0x27, 0x0f, 1}
+	if !bytes.HasPrefix(attrs[0], want) {
+		t.Errorf("got %x, want it to start with %x", attrs[0][:9], want)
+	}
+	if attrs[1][2] != ExtendedVendorSpecific || attrs[1][3] != 0 || len(attrs[1][4:])+len(attrs[0][9:]) != len(value) {
+		t.Errorf("last fragment %x", attrs[1])
+	}
+
+	if name := parsed.Attributes[0].ID(); !name.Equal(*blob) {
+		t.Errorf("parsed as %v", name)
+	}
+}
+
+// TLVs of the same attribute go in a single one, nested ones inside their
+// parent.
+func TestNestedTLVs(t *testing.T) {
+
+	d := extendedDictionary(t)
+	short := &AttributeID{0, []uint32{241, 1}}
+	number := &AttributeID{0, []uint32{241, 2, 1}}
+	name := &AttributeID{0, []uint32{241, 2, 2, 1}}
+
+	attrs := map[*AttributeID][]byte{
+		short:  []byte("plain"),
+		number: {0, 0, 0, 5},
+		name:   []byte("eth0"),
+	}
+	raw, _ := roundTrip(t, d, attrs, short, number, name)
+
+	want := [][]byte{
+		append([]byte{241, 8, 1}, "plain"...),
+		{241, 17, 2, 1, 6, 0, 0, 0, 5, 2, 8, 1, 6, 'e', 't', 'h', '0'},
+	}
+	got := rawAttributes(t, raw)
+	if len(got) != len(want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("attribute %v is %x, want %x", i, got[i], want[i])
+		}
+	}
+
+	// a TLV too long for its parent
+	p := d.NewPacket()
+	p.Code = AccessRequest
+	p.AddAttributeByID(*name, bytes.Repeat([]byte{'x'}, 250))
+	if _, err := EncodeAndSign(p, "secret"); err == nil {
+		t.Errorf("TLV longer than its parent encoded")
+	}
+}
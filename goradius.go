@@ -1,15 +1,16 @@
 package goradius
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/subtle"
+	"crypto/tls"
 	"errors"
-	"io/ioutil"
 	"log"
 	"net"
-	"regexp"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,11 +18,13 @@ const (
 	authenticatorLength = 16
 )
 
+// Deprecated: vendor attributes are kept in DefaultDictionary, these are
+// filled by LoadVSAFile for existing code.
 var (
-	VSAs        map[string]VendorSpecificAttribute
-	Vendors     map[string]uint32
-	VSAsLock    *sync.RWMutex
-	VendorsLock *sync.RWMutex
+	VSAs        = make(map[string]VendorSpecificAttribute)
+	Vendors     = make(map[string]uint32)
+	VSAsLock    = new(sync.RWMutex)
+	VendorsLock = new(sync.RWMutex)
 )
 
 type RADIUSMiddleware func(*RadiusServer, *RadiusPacket, *RadiusPacket) (bool, bool)
@@ -30,12 +33,46 @@ type RadiusServer struct {
 	Secret     string
 	handler    func(*RadiusPacket, *RadiusPacket) (bool, bool)   // option 1
 	middleware []func(*RadiusPacket, *RadiusPacket) (bool, bool) // option 2
-	conn       *net.UDPConn
 	Sessions   map[string]bool
 	Routes     map[uint8][]RADIUSMiddleware // option 3
+	handlers   map[uint8]Handler            // option 4
 	OnDrop     func(*RadiusServer, *RadiusPacket, *RadiusPacket)
 	OnReply    func(*RadiusServer, *RadiusPacket, *RadiusPacket)
+	OnError    func(*RadiusServer, *RadiusPacket, error)
 	Mode       rune
+
+	// Clients restricts which NASes the server answers and holds their
+	// secrets. When nil every source is accepted and Secret is used.
+	Clients *ClientRegistry
+
+	// DuplicateTTL is how long a reply is kept to answer retransmissions of
+	// the same request. Zero disables duplicate detection.
+	DuplicateTTL time.Duration
+
+	// IdleTimeout closes TCP and TLS connections that didn't send a request
+	// for that long. Zero keeps them open.
+	IdleTimeout time.Duration
+
+	// TLSConfig is used by ListenAndServeTLS, which refuses to serve without
+	// ClientCAs when it verifies client certificates.
+	TLSConfig *tls.Config
+
+	// Dictionary decodes requests and encodes responses, DefaultDictionary
+	// when nil.
+	Dictionary *Dictionary
+
+	// EAP, when set, authenticates the Access-Requests carrying EAP before
+	// they reach their handler, see EAPServer.Middleware.
+	EAP *EAPServer
+
+	// ChallengeTimeout is how long the data given to ChallengeWith is kept,
+	// DefaultChallengeTimeout when zero.
+	ChallengeTimeout time.Duration
+
+	duplicates *duplicateCache
+	challenges stateStore
+	stats      serverStats
+	lifecycle
 }
 
 // func(req, res) (next, drop)
@@ -52,22 +89,9 @@ func NewRadiusServer(mode rune) *RadiusServer {
 	r.Mode = mode
 	r.Sessions = make(map[string]bool)
 	r.Routes = make(map[uint8][]RADIUSMiddleware)
-
-	if VSAs == nil {
-		VSAs = make(map[string]VendorSpecificAttribute)
-	}
-
-	if Vendors == nil {
-		Vendors = make(map[string]uint32)
-	}
-
-	if VSAsLock == nil {
-		VSAsLock = new(sync.RWMutex)
-	}
-
-	if VendorsLock == nil {
-		VendorsLock = new(sync.RWMutex)
-	}
+	r.DuplicateTTL = DefaultDuplicateTTL
+	r.IdleTimeout = DefaultIdleTimeout
+	r.duplicates = newDuplicateCache()
 
 	return &r
 }
@@ -76,27 +100,61 @@ func (r *RadiusServer) ListenAndServe(addr_str, secret string) error {
 
 	r.Secret = secret
 
+	if r.shuttingDown() {
+		return ErrServerClosed
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", addr_str)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
-	conn.SetReadBuffer(1048576)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	conn.SetReadBuffer(1048576)
+
+	return r.Serve(conn)
+}
+
+// Serve reads requests from conn until it fails or the server is shut
+// down, in which case ErrServerClosed is returned. conn is closed once the
+// server stops using it.
+func (r *RadiusServer) Serve(conn net.PacketConn) error {
+
+	if !r.trackConn(conn, true) {
+		conn.Close()
+		return ErrServerClosed
 	}
-	r.conn = conn
 
 	for {
 
 		bufr := make([]byte, 4096)
-		rawMsgSize, addr, err := conn.ReadFromUDP(bufr)
+		rawMsgSize, addr, err := conn.ReadFrom(bufr)
 		if err != nil {
-			panic(err)
+			if r.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			r.trackConn(conn, false)
+			conn.Close()
+			return err
 		}
 
-		go r.handleConn(rawMsgSize, addr, bufr)
+		if !r.startRequest() {
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer r.inFlight.Done()
+			r.handleConn(rawMsgSize, udpAddr(addr), bufr, func(reply []byte) error {
+				_, err := conn.WriteTo(reply, addr)
+				return err
+			})
+		}()
 
 	}
 
@@ -131,64 +189,122 @@ func (r *RadiusServer) handleMiddleware(mid []RADIUSMiddleware, req, res *Radius
 	return false
 }
 
-func (r *RadiusServer) handleConn(rawMsgSize int, addr *net.UDPAddr, data []byte) {
+// handleConn answers a single request, write sends the reply back over the
+// transport the request came in on.
+func (r *RadiusServer) handleConn(rawMsgSize int, addr *net.UDPAddr, data []byte, write func([]byte) error) {
 
-	if rawMsgSize < 20 {
-		return // errors.New("Message to short.")
+	if rawMsgSize < headerEnd {
+		r.stats.drop(DropMalformed)
+		return
 	}
 
-	rawMsg := data[0:rawMsgSize]
-
-	requestPacket, err := ParseRADIUSPacket(rawMsg, r.Secret)
-	requestPacket.Addr = addr
+	client, ok := r.findClient(addr)
+	if !ok {
+		r.stats.drop(DropUnknownClient)
+		return
+	}
 
-	responsePacket := NewRadiusPacket()
-	responsePacket.RadiusHeader = requestPacket.RadiusHeader
+	r.handleRequest(data[0:rawMsgSize], addr, client, write)
+}
 
-	drop := true
-	routeMatched := false
+// handleRequest runs a request from client through the checks and handlers
+// of the server, whatever transport it came in on.
+func (r *RadiusServer) handleRequest(rawMsg []byte, addr *net.UDPAddr, client *RadiusClient, write func([]byte) error) {
 
-	var policyFlow []RADIUSMiddleware
-	ok := false
+	requestPacket, err := r.dictionary().ParsePacket(rawMsg, client.Secret)
+	if err != nil {
+		log.Printf("Dropping packet from %v (%v). %v", addr, client.Name, err)
+		r.stats.drop(DropMalformed)
+		return
+	}
+	// anything after Length is padding and isn't authenticated
+	rawMsg = rawMsg[:requestPacket.Length]
+	requestPacket.Addr = addr
+	requestPacket.Client = client
 
-	if requestPacket.Code == StatusServer {
-		if policyFlow, ok = r.Routes[StatusServer]; ok {
-			routeMatched = true
-		}
+	if hasHashedAuthenticator(requestPacket.Code) && !VerifyRequestAuthenticator(rawMsg, client.Secret) {
+		log.Printf("Dropping packet from %v (%v). Invalid Request Authenticator.", addr, client.Name)
+		r.stats.drop(DropBadAuthenticator)
+		return
 	}
 
-	if requestPacket.Code == AccessRequest {
-		if policyFlow, ok = r.Routes[AccessRequest]; ok {
-			routeMatched = true
+	hasMessageAuthenticator := false
+	if client.MessageAuthenticator != MessageAuthenticatorOff {
+		present, valid := VerifyMessageAuthenticator(rawMsg, nil, client.Secret)
+		// requests with EAP must have one (RFC 3579 §3.3)
+		required := requiresMessageAuthenticator(client, requestPacket.Code) || hasEAPMessage(requestPacket)
+		if (present && !valid) || (!present && required) {
+			log.Printf("Dropping packet from %v (%v). Missing or invalid Message-Authenticator.", addr, client.Name)
+			r.stats.drop(DropMessageAuthenticator)
+			return
 		}
+		hasMessageAuthenticator = present
 	}
 
-	if requestPacket.Code == AccountingRequest {
-		if policyFlow, ok = r.Routes[AccountingRequest]; ok {
-			routeMatched = true
+	var reply []byte
+	if r.duplicates != nil && r.DuplicateTTL > 0 {
+
+		key := duplicateKey(addr, requestPacket.Identifier, requestPacket.Authenticator)
+		if cached := r.duplicates.begin(key, r.DuplicateTTL); cached != nil {
+			if cached.response == nil {
+				r.stats.drop(DropDuplicate)
+				return
+			}
+			atomic.AddUint64(&r.stats.replayed, 1)
+			write(cached.response)
+			return
 		}
+
+		defer func() {
+			r.duplicates.finish(key, reply, r.DuplicateTTL)
+		}()
 	}
 
-	if routeMatched {
-		drop = r.handleMiddleware(policyFlow, requestPacket, responsePacket)
-	} else {
-		log.Printf("routeMatched %v. Did not find route for packet\n%+v", routeMatched, requestPacket)
+	responsePacket := r.dictionary().NewPacket()
+	responsePacket.RadiusHeader = requestPacket.RadiusHeader
+
+	handler := r.route(requestPacket.Code)
+	if handler == nil {
+		log.Printf("Did not find route for packet\n%+v", requestPacket)
 		log.Printf("Dropping packet. Server mode: %v", r.Mode)
 		return
 	}
 
+	ctx := context.WithValue(r.baseCtx, serverContextKey, r)
+	ctx = r.resumeChallenge(ctx, requestPacket)
+	w := &responseWriter{request: requestPacket, response: responsePacket, server: r}
+
+	err = handler.ServeRADIUS(ctx, w, requestPacket)
+	if err != nil && err != ErrDrop {
+		log.Printf("Handler error for %v (%v): %v", addr, client.Name, err)
+		if r.OnError != nil {
+			r.OnError(r, requestPacket, err)
+		}
+	}
+
+	drop := w.dropped || err != nil
+
 	if drop {
+		r.stats.drop(DropPolicy)
 		if r.OnDrop != nil {
 			r.OnDrop(r, requestPacket, nil)
 		}
 		return
 	}
 
-	// sometimes we want to silently drop packets
-	// so this should be moved out of here.
-	err = SendPacket(r.conn, addr, responsePacket, r.Secret)
+	if hasMessageAuthenticator || requiresMessageAuthenticator(client, requestPacket.Code) {
+		responsePacket.addMessageAuthenticator()
+	}
+
+	reply, err = EncodeAndSign(responsePacket, client.Secret)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Failed to encode response for %v: %v", addr, err)
+		return
+	}
+
+	err = write(reply)
+	if err != nil {
+		log.Printf("Failed to send response to %v: %v", addr, err)
 	}
 
 	if r.OnReply != nil {
@@ -198,6 +314,26 @@ func (r *RadiusServer) handleConn(rawMsgSize int, addr *net.UDPAddr, data []byte
 	return
 }
 
+func (r *RadiusServer) dictionary() *Dictionary {
+
+	if r.Dictionary == nil {
+		return DefaultDictionary
+	}
+
+	return r.Dictionary
+}
+
+// findClient matches the source address against the client registry. Without
+// a registry every source is a client that uses the server wide secret.
+func (r *RadiusServer) findClient(addr *net.UDPAddr) (*RadiusClient, bool) {
+
+	if r.Clients == nil {
+		return &RadiusClient{Secret: r.Secret}, true
+	}
+
+	return r.Clients.Find(addr.IP)
+}
+
 func CalculateResponseAuthenticator(output []byte, secret string) {
 
 	md5c := md5.New()
@@ -213,8 +349,12 @@ func CalculateResponseAuthenticator(output []byte, secret string) {
 	}
 }
 
+// CalculateAuthenticator sets the Request Authenticator of requests that
+// carry a hash of their contents instead of a random value (RFC 2866 §3).
 func CalculateAuthenticator(output []byte, secret string) {
 
+	copy(output[4:headerEnd], ZeroedAuthenticator[:])
+
 	md5c := md5.New()
 	md5c.Write(output)
 	md5c.Write([]byte(secret))
@@ -228,146 +368,175 @@ func CalculateAuthenticator(output []byte, secret string) {
 	}
 }
 
-func SendPacket(conn *net.UDPConn, addr *net.UDPAddr, packet *RadiusPacket, secret string) error {
+// VerifyRequestAuthenticator checks the Request Authenticator of a raw
+// Accounting-Request style packet against secret.
+func VerifyRequestAuthenticator(rawMsg []byte, secret string) bool {
 
-	output, err := packet.EncodePacket(secret)
-	if err != nil {
-		return err
+	if len(rawMsg) < headerEnd {
+		return false
 	}
 
-	if packet.Code == 2 || packet.Code == 5 {
-		CalculateResponseAuthenticator(output, secret)
-	}
+	check := make([]byte, len(rawMsg))
+	copy(check, rawMsg)
+	CalculateAuthenticator(check, secret)
 
-	if packet.Code == 4 {
-		CalculateAuthenticator(output, secret)
-	}
+	return subtle.ConstantTimeCompare(check[4:headerEnd], rawMsg[4:headerEnd]) == 1
+}
 
-	bytesWritten, err := conn.WriteToUDP(output, addr)
-	if bytesWritten != int(packet.Length) {
-		log.Printf("WARNING: Written bytes in UDP socket did not match packet size. Packet: %v Written: %v",
-			packet.Length, bytesWritten)
+// VerifyResponseAuthenticator checks the Response Authenticator of a raw
+// reply to a request that had requestAuthenticator.
+func VerifyResponseAuthenticator(rawMsg []byte, requestAuthenticator []byte, secret string) bool {
+
+	if len(rawMsg) < headerEnd {
+		return false
 	}
 
-	return err
-}
+	check := make([]byte, len(rawMsg))
+	copy(check, rawMsg)
+	copy(check[4:headerEnd], requestAuthenticator)
+	CalculateResponseAuthenticator(check, secret)
 
-func FindVSA(attr_name string) (VendorSpecificAttribute, error) {
+	return subtle.ConstantTimeCompare(check[4:headerEnd], rawMsg[4:headerEnd]) == 1
+}
 
-	VSAsLock.RLock()
-	vsa, ok := VSAs[attr_name]
-	VSAsLock.RUnlock()
+// hasHashedAuthenticator reports whether requests with code carry an MD5
+// of the packet and the secret in the authenticator field.
+func hasHashedAuthenticator(code uint8) bool {
 
-	if ok {
-		return vsa, nil
-	} else {
-		return VendorSpecificAttribute{}, errors.New("VSA not found.")
+	switch code {
+	case AccountingRequest, DisconnectRequest, CoARequest:
+		return true
 	}
+
+	return false
 }
 
-func LoadVSAFile(path string) {
+// isRequestCode reports whether packets with code are requests a server answers.
+func isRequestCode(code uint8) bool {
 
-	if VSAs == nil {
-		VSAs = make(map[string]VendorSpecificAttribute)
+	switch code {
+	case AccessRequest, AccountingRequest, StatusServer, DisconnectRequest, CoARequest:
+		return true
 	}
 
-	if Vendors == nil {
-		Vendors = make(map[string]uint32)
-	}
+	return false
+}
 
-	if VSAsLock == nil {
-		VSAsLock = new(sync.RWMutex)
-	}
+// isResponseCode reports whether packets with code are replies that carry a
+// Response Authenticator.
+func isResponseCode(code uint8) bool {
 
-	if VendorsLock == nil {
-		VendorsLock = new(sync.RWMutex)
+	switch code {
+	case AccessAccept, AccessReject, AccountingResponse, AccessChallenge,
+		DisconnectACK, DisconnectNAK, CoAACK, CoANAK:
+		return true
 	}
 
-	data, err := ioutil.ReadFile(path)
+	return false
+}
+
+// EncodeAndSign encodes packet and fills in the authenticators that are
+// calculated over the encoded bytes, giving what goes on the wire.
+func EncodeAndSign(packet *RadiusPacket, secret string) ([]byte, error) {
+
+	output, err := packet.EncodePacket(secret)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	data_str := string(data)
-	lines := strings.Split(data_str, "\n")
-
-	// Extract all vendors
-	expr := `^VENDOR\s(?P<vendor_name>\w+)\s(?P<vendor_id>\d+)$`
-	exp, err := regexp.Compile(expr)
+	if isResponseCode(packet.Code) {
+		CalculateResponseAuthenticator(output, secret)
+	}
 
-	ctr := 0
+	if hasHashedAuthenticator(packet.Code) {
+		CalculateAuthenticator(output, secret)
+	}
 
-	VendorsLock.Lock()
-	for _, line := range lines {
+	return output, nil
+}
 
-		if len(line) == 0 {
-			continue
-		}
+func SendPacket(conn net.PacketConn, addr net.Addr, packet *RadiusPacket, secret string) error {
 
-		matches := exp.FindStringSubmatch(line)
-		if len(matches) > 0 {
-			vendor_name := strings.Trim(matches[1], " \t")
-			vendor_id_int, _ := strconv.Atoi(matches[2])
-			vendor_id := uint32(vendor_id_int)
-			Vendors[vendor_name] = vendor_id
-			ctr += 1
-		}
+	output, err := EncodeAndSign(packet, secret)
+	if err != nil {
+		return err
+	}
 
+	bytesWritten, err := conn.WriteTo(output, addr)
+	if bytesWritten != int(packet.Length) {
+		log.Printf("WARNING: Written bytes in UDP socket did not match packet size. Packet: %v Written: %v",
+			packet.Length, bytesWritten)
 	}
-	VendorsLock.Unlock()
 
-	log.Printf("Vendors loaded: %v", ctr)
+	return err
+}
 
-	// should match this:
-	// s := `ATTRIBUTE	BW-Venue-Id		7	string	Boingo`
-	attr_expr := `^ATTRIBUTE\s(?P<attribute>.+)\s(?P<code>\d+)\s(?P<content_type>\w+)\s(?P<vendor_name>\w+)$`
-	attr_exp, err := regexp.Compile(attr_expr)
-	if err != nil {
-		panic(err)
-	}
+func FindVSA(attr_name string) (VendorSpecificAttribute, error) {
+	return DefaultDictionary.FindVSA(attr_name)
+}
 
-	VendorsLock.RLock()
-	VSAsLock.Lock()
+// FindVSA looks up a vendor attribute that fits in a plain Vendor-Specific.
+// DefaultDictionary also finds the ones added to VSAs by hand.
+func (d *Dictionary) FindVSA(attr_name string) (VendorSpecificAttribute, error) {
 
-	ctr = 0
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
+	if d == DefaultDictionary {
+		VSAsLock.RLock()
+		vsa, ok := VSAs[attr_name]
+		VSAsLock.RUnlock()
 
-		matches := attr_exp.FindStringSubmatch(line)
-		if len(matches) == 5 {
+		if ok {
+			return vsa, nil
+		}
+	}
 
-			attr_name := strings.Trim(matches[1], " \t")
-			attr_code_str := strings.Trim(matches[2], " \t")
-			// attr_content_type := strings.Trim(matches[3], " \t")
-			attr_vendor := strings.Trim(matches[4], " \t")
-			attr_code, _ := strconv.Atoi(attr_code_str)
+	if attr, ok := d.FindAttribute(attr_name); ok && attr.Vendor != nil && attr.Vendor.Extended == 0 && len(attr.OID) == 1 && attr.Code() <= 255 {
+		return VendorSpecificAttribute{
+			VendorId:   attr.Vendor.Id,
+			VendorType: uint8(attr.Code()),
+		}, nil
+	}
 
-			// current := fmt.Sprintf("%v %v %v %v", attr_name, attr_code_str, attr_content_type, attr_vendor)
+	return VendorSpecificAttribute{}, errors.New("VSA not found.")
+}
 
-			if _, exists := VSAs[attr_name]; exists {
-				log.Printf("[WARNING] Duplicate VSA not stored: %v", attr_name)
-			} else {
+// LoadVSAFile loads a dictionary file into DefaultDictionary and also fills
+// VSAs and Vendors with the vendor attributes it defines.
+//
+// Deprecated: use EnableVendor for the bundled vendors, LoadDictionary or
+// Dictionary.Load otherwise. VSAs and Vendors are only kept for existing
+// code.
+func LoadVSAFile(path string) error {
 
-				vendor_id := Vendors[attr_vendor]
+	err := LoadDictionary(path)
+	if err != nil {
+		return err
+	}
 
-				vsa := VendorSpecificAttribute{
-					VendorId:   vendor_id,
-					VendorType: uint8(attr_code),
-				}
+	vendors := DefaultDictionary.Vendors()
 
-				VSAs[attr_name] = vsa
-				ctr += 1
+	VendorsLock.Lock()
+	for _, vendor := range vendors {
+		Vendors[vendor.Name] = vendor.Id
+	}
+	VendorsLock.Unlock()
 
-			}
+	log.Printf("Vendors loaded: %v", len(vendors))
 
+	VSAsLock.Lock()
+	ctr := 0
+	for _, attr := range DefaultDictionary.Attributes() {
+		if attr.Vendor == nil || len(attr.OID) != 1 || attr.Code() > 255 {
+			continue
 		}
+		VSAs[attr.Name] = VendorSpecificAttribute{
+			VendorId:   attr.Vendor.Id,
+			VendorType: uint8(attr.Code()),
+		}
+		ctr += 1
 	}
-
-	VendorsLock.RUnlock()
 	VSAsLock.Unlock()
 
 	log.Printf("VSAs loaded: %v", ctr)
 
+	return nil
 }
@@ -0,0 +1,209 @@
+package goradius
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// handleRaw runs an encoded request from client through r, and returns the
+// reply or nil when it was dropped.
+func handleRaw(r *RadiusServer, client *RadiusClient, raw []byte) []byte {
+
+	r.initLifecycle()
+
+	var reply []byte
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1812}
+	r.handleRequest(raw, addr, client, func(b []byte) error {
+		reply = b
+		return nil
+	})
+
+	return reply
+}
+
+func acceptingServer() *RadiusServer {
+
+	r := NewRadiusServer('a')
+	r.DuplicateTTL = 0
+	r.HandleFunc(AccessRequest, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		w.Response().Code = AccessAccept
+		return nil
+	})
+	r.HandleFunc(StatusServer, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		w.Response().Code = AccessAccept
+		return nil
+	})
+
+	return r
+}
+
+func accessRequest(t *testing.T, secret string, messageAuthenticator bool) []byte {
+
+	t.Helper()
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.Identifier = 7
+	copy(req.Authenticator[:], randomBytes(authenticatorLength))
+	req.AddAttribute("User-Name", []byte("steve"))
+	if messageAuthenticator {
+		req.addMessageAuthenticator()
+	}
+
+	raw, err := EncodeAndSign(req, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func TestMessageAuthenticatorPolicy(t *testing.T) {
+
+	valid := accessRequest(t, "secret", true)
+	bad := append([]byte{}, valid...)
+	bad[len(bad)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		policy MessageAuthenticatorPolicy
+		raw    []byte
+		ok     bool
+	}{
+		{"Require without", MessageAuthenticatorRequire, accessRequest(t, "secret", false), false},
+		{"Require valid", MessageAuthenticatorRequire, valid, true},
+		{"Require bad", MessageAuthenticatorRequire, bad, false},
+		{"IfPresent without", MessageAuthenticatorIfPresent, accessRequest(t, "secret", false), true},
+		{"IfPresent valid", MessageAuthenticatorIfPresent, valid, true},
+		{"IfPresent bad", MessageAuthenticatorIfPresent, bad, false},
+		{"Off bad", MessageAuthenticatorOff, bad, true},
+	}
+
+	for _, tt := range tests {
+
+		r := acceptingServer()
+		client := &RadiusClient{Name: "nas", Secret: "secret", MessageAuthenticator: tt.policy}
+
+		reply := handleRaw(r, client, tt.raw)
+		if (reply != nil) != tt.ok {
+			t.Errorf("%v: got reply %v, want %v", tt.name, reply != nil, tt.ok)
+		}
+
+		dropped := r.Dropped(DropMessageAuthenticator)
+		if tt.ok && dropped != 0 || !tt.ok && dropped != 1 {
+			t.Errorf("%v: %v drops counted", tt.name, dropped)
+		}
+	}
+}
+
+// The reply signs over the authenticator of the request (RFC 3579 §3.2), so
+// it can't be moved to another request.
+func TestMessageAuthenticatorReply(t *testing.T) {
+
+	r := acceptingServer()
+	client := &RadiusClient{Name: "nas", Secret: "xyzzy5461", MessageAuthenticator: MessageAuthenticatorRequire}
+
+	reply := handleRaw(r, client, rfc5997StatusServer)
+	if reply == nil {
+		t.Fatal("RFC 5997 Status-Server dropped")
+	}
+
+	requestAuthenticator := rfc5997StatusServer[4:headerEnd]
+	present, valid := VerifyMessageAuthenticator(reply, requestAuthenticator, "xyzzy5461")
+	if !present || !valid {
+		t.Errorf("got present %v valid %v, want a valid Message-Authenticator", present, valid)
+	}
+	if !VerifyResponseAuthenticator(reply, requestAuthenticator, "xyzzy5461") {
+		t.Errorf("invalid Response Authenticator")
+	}
+
+	other := make([]byte, authenticatorLength)
+	if _, valid := VerifyMessageAuthenticator(reply, other, "xyzzy5461"); valid {
+		t.Errorf("valid over another Request Authenticator")
+	}
+}
+
+// accountingStart is an Accounting-Request with Acct-Status-Type Start and
+// User-Name "steve", its authenticator the MD5 of RFC 2866 §3 with the
+// secret "xyzzy5461".
+var accountingStart = unhex("042b0021840e61c7d72ede84fba6c7938d736614" +
+	"28060000000101077374657665")
+
+func TestVerifyRequestAuthenticator(t *testing.T) {
+
+	if !VerifyRequestAuthenticator(accountingStart, "xyzzy5461") {
+		t.Errorf("valid Accounting-Request rejected")
+	}
+	if VerifyRequestAuthenticator(accountingStart, "xyzzy5462") {
+		t.Errorf("valid with the wrong secret")
+	}
+
+	forged := append([]byte{}, accountingStart...)
+	forged[len(forged)-1] ^= 1
+	if VerifyRequestAuthenticator(forged, "xyzzy5461") {
+		t.Errorf("valid with a changed attribute")
+	}
+
+	if VerifyRequestAuthenticator(accountingStart[:10], "xyzzy5461") {
+		t.Errorf("valid without a whole header")
+	}
+}
+
+func TestRequestAuthenticatorPolicy(t *testing.T) {
+
+	signed := func(code uint8) []byte {
+		req := NewRadiusPacket()
+		req.Code = code
+		req.Identifier = 9
+		req.AddAttribute("User-Name", []byte("steve"))
+		raw, err := EncodeAndSign(req, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	forge := func(raw []byte) []byte {
+		forged := append([]byte{}, raw...)
+		forged[len(forged)-1] ^= 1
+		return forged
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		raw    []byte
+		ok     bool
+	}{
+		{"Accounting-Request", "xyzzy5461", accountingStart, true},
+		{"forged Accounting-Request", "xyzzy5461", forge(accountingStart), false},
+		{"CoA-Request", "secret", signed(CoARequest), true},
+		{"forged CoA-Request", "secret", forge(signed(CoARequest)), false},
+		{"Disconnect-Request", "secret", signed(DisconnectRequest), true},
+		{"Disconnect-Request with the wrong secret", "other", signed(DisconnectRequest), false},
+	}
+
+	for _, tt := range tests {
+
+		r := NewRadiusServer('a')
+		r.DuplicateTTL = 0
+		for _, code := range []uint8{AccountingRequest, CoARequest, DisconnectRequest} {
+			r.HandleFunc(code, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+				// Accounting-Response, Disconnect-ACK and CoA-ACK follow their request
+				w.Response().Code = req.Code + 1
+				return nil
+			})
+		}
+		client := &RadiusClient{Name: "nas", Secret: tt.secret}
+
+		reply := handleRaw(r, client, tt.raw)
+		if (reply != nil) != tt.ok {
+			t.Errorf("%v: got reply %v, want %v", tt.name, reply != nil, tt.ok)
+		}
+
+		dropped := r.Dropped(DropBadAuthenticator)
+		if tt.ok && dropped != 0 || !tt.ok && dropped != 1 {
+			t.Errorf("%v: %v drops counted", tt.name, dropped)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package goradius
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDrop can be returned by a Handler to silently drop the request.
+var ErrDrop = errors.New("Packet dropped.")
+
+// Handler answers a RADIUS request, in the style of http.Handler. The reply
+// is built on w.Response(). Returning an error or calling w.Drop() discards
+// the reply.
+type Handler interface {
+	ServeRADIUS(ctx context.Context, w ResponseWriter, req *RadiusPacket) error
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+type HandlerFunc func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error
+
+func (f HandlerFunc) ServeRADIUS(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+	return f(ctx, w, req)
+}
+
+// ResponseWriter gives handlers access to the reply of a request.
+type ResponseWriter interface {
+	// Response is the packet that will be sent back. Its header starts as
+	// a copy of the request's.
+	Response() *RadiusPacket
+	// Drop discards the reply, nothing is sent to the client.
+	Drop()
+	// ChallengeWith turns the reply into an Access-Challenge, with prompt
+	// as its Reply-Message and a new State. The Access-Request answering it
+	// gets data back through ChallengeFromContext.
+	ChallengeWith(prompt string, data interface{})
+}
+
+type responseWriter struct {
+	request  *RadiusPacket
+	response *RadiusPacket
+	dropped  bool
+	server   *RadiusServer
+}
+
+func (w *responseWriter) Response() *RadiusPacket {
+	return w.response
+}
+
+func (w *responseWriter) Drop() {
+	w.dropped = true
+}
+
+// Middleware wraps a Handler to run code before and/or after it.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with middleware. The first middleware is the outermost one
+// and sees the request first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h
+}
+
+// Timeout gives every request handled by the wrapped Handler a deadline.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next.ServeRADIUS(ctx, w, req)
+		})
+	}
+}
+
+type contextKey int
+
+const (
+	serverContextKey contextKey = iota
+)
+
+// ServerFromContext returns the server handling the request ctx belongs to.
+func ServerFromContext(ctx context.Context) *RadiusServer {
+	r, _ := ctx.Value(serverContextKey).(*RadiusServer)
+	return r
+}
+
+// RoutesHandler adapts a Routes policy flow to a Handler. The flow runs
+// until a middleware returns next == false, drop == true drops the request.
+func RoutesHandler(mid ...RADIUSMiddleware) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		if ServerFromContext(ctx).handleMiddleware(mid, req, w.Response()) {
+			return ErrDrop
+		}
+		return nil
+	})
+}
+
+// FuncsHandler adapts functions given to Use or Handler to a Handler.
+func FuncsHandler(funcs ...func(*RadiusPacket, *RadiusPacket) (bool, bool)) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+
+		for _, f := range funcs {
+
+			next, drop := f(req, w.Response())
+
+			if drop {
+				return ErrDrop
+			}
+
+			if !next {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+// Handle registers h for requests with code. It takes precedence over Routes.
+func (r *RadiusServer) Handle(code uint8, h Handler) {
+
+	if r.handlers == nil {
+		r.handlers = make(map[uint8]Handler)
+	}
+
+	r.handlers[code] = h
+}
+
+func (r *RadiusServer) HandleFunc(code uint8, f func(context.Context, ResponseWriter, *RadiusPacket) error) {
+	r.Handle(code, HandlerFunc(f))
+}
+
+// route finds the Handler for requests with code, behind the EAP server for
+// Access-Requests.
+func (r *RadiusServer) route(code uint8) Handler {
+
+	h := r.findRoute(code)
+
+	if code == AccessRequest && r.EAP != nil {
+		if h == nil {
+			h = HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+				return nil
+			})
+		}
+		h = r.EAP.Middleware()(h)
+	}
+
+	return h
+}
+
+// findRoute finds the Handler for requests with code: one given to Handle,
+// then Routes, then the functions given to Handler and Use. Those functions
+// only see requests, other codes need a route of their own.
+func (r *RadiusServer) findRoute(code uint8) Handler {
+
+	if h, ok := r.handlers[code]; ok {
+		return h
+	}
+
+	if mid, ok := r.Routes[code]; ok {
+		return RoutesHandler(mid...)
+	}
+
+	if !isRequestCode(code) {
+		return nil
+	}
+
+	var funcs []func(*RadiusPacket, *RadiusPacket) (bool, bool)
+	if r.handler != nil {
+		funcs = append(funcs, r.handler)
+	}
+	funcs = append(funcs, r.middleware...)
+
+	if len(funcs) > 0 {
+		return FuncsHandler(funcs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package goradius
+
+import "testing"
+
+func TestFindRouteLegacyFuncsOnlyForRequests(t *testing.T) {
+
+	r := NewRadiusServer('a')
+	r.Handler(func(req, res *RadiusPacket) (bool, bool) {
+		return true, false
+	})
+
+	for _, code := range []uint8{AccessRequest, AccountingRequest, StatusServer, DisconnectRequest, CoARequest} {
+		if r.findRoute(code) == nil {
+			t.Errorf("no route for request code %v", code)
+		}
+	}
+
+	for _, code := range []uint8{AccessAccept, AccessReject, AccountingResponse, AccessChallenge, CoAACK, 0, 255} {
+		if r.findRoute(code) != nil {
+			t.Errorf("code %v reached the legacy handler", code)
+		}
+	}
+
+	r.HandleFunc(AccessAccept, nil)
+	if r.findRoute(AccessAccept) == nil {
+		t.Errorf("explicit route for Access-Accept ignored")
+	}
+}
@@ -0,0 +1,187 @@
+package goradius
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown or
+// Close was called.
+var ErrServerClosed = errors.New("Server closed.")
+
+// lifecycle keeps track of the connections a server reads from and the
+// requests it is handling so it can be stopped.
+type lifecycle struct {
+	lifecycleOnce sync.Once
+	lifecycleLock sync.Mutex
+	closing       bool
+	conns         map[io.Closer]struct{}
+	inFlight      sync.WaitGroup
+	baseCtx       context.Context
+	cancel        context.CancelFunc
+}
+
+func (l *lifecycle) initLifecycle() {
+	l.lifecycleOnce.Do(func() {
+		l.conns = make(map[io.Closer]struct{})
+		l.baseCtx, l.cancel = context.WithCancel(context.Background())
+	})
+}
+
+func (l *lifecycle) shuttingDown() bool {
+
+	l.lifecycleLock.Lock()
+	defer l.lifecycleLock.Unlock()
+
+	return l.closing
+}
+
+// trackConn adds or removes conn from the connections closed on shutdown.
+// It returns false if the server is already shutting down.
+func (l *lifecycle) trackConn(conn io.Closer, add bool) bool {
+
+	l.initLifecycle()
+
+	l.lifecycleLock.Lock()
+	defer l.lifecycleLock.Unlock()
+
+	if add {
+		if l.closing {
+			return false
+		}
+		l.conns[conn] = struct{}{}
+	} else {
+		delete(l.conns, conn)
+	}
+
+	return true
+}
+
+// startRequest registers a request being handled. The caller must call
+// inFlight.Done() once it is finished.
+func (l *lifecycle) startRequest() bool {
+
+	l.lifecycleLock.Lock()
+	defer l.lifecycleLock.Unlock()
+
+	if l.closing {
+		return false
+	}
+
+	l.inFlight.Add(1)
+
+	return true
+}
+
+// stop stops accepting requests. Connections that support it get a read
+// deadline so they can still send the replies of the requests in flight,
+// the rest are closed.
+func (l *lifecycle) stop() error {
+
+	l.initLifecycle()
+
+	l.lifecycleLock.Lock()
+	defer l.lifecycleLock.Unlock()
+
+	l.closing = true
+
+	var err error
+	for conn := range l.conns {
+
+		if d, ok := conn.(interface {
+			SetReadDeadline(time.Time) error
+		}); ok {
+			if d.SetReadDeadline(time.Now()) == nil {
+				continue
+			}
+		}
+
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(l.conns, conn)
+	}
+
+	return err
+}
+
+func (l *lifecycle) closeConns() error {
+
+	l.lifecycleLock.Lock()
+	defer l.lifecycleLock.Unlock()
+
+	var err error
+	for conn := range l.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(l.conns, conn)
+	}
+
+	return err
+}
+
+// Shutdown stops the server from reading new requests and waits for the
+// ones being handled to finish or for ctx to be done. When ctx expires the
+// context of the remaining handlers is cancelled and ctx's error returned.
+func (r *RadiusServer) Shutdown(ctx context.Context) error {
+
+	err := r.stop()
+
+	done := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r.cancel()
+	if cerr := r.closeConns(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Close stops the server immediately, cancelling the context of the
+// requests being handled without waiting for them.
+func (r *RadiusServer) Close() error {
+
+	err := r.stop()
+	r.cancel()
+	if cerr := r.closeConns(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// udpAddr converts the source address of a request to the *net.UDPAddr kept
+// in RadiusPacket.Addr.
+func udpAddr(addr net.Addr) *net.UDPAddr {
+
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	}
+
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return &net.UDPAddr{}
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+}
@@ -0,0 +1,137 @@
+package goradius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+)
+
+const messageAuthenticatorLength = 16
+
+// MessageAuthenticatorPolicy controls how the Message-Authenticator
+// attribute (RFC 3579 §3.2) of packets coming from a client is checked.
+type MessageAuthenticatorPolicy int
+
+const (
+	// Validate the attribute when the client sends it
+	MessageAuthenticatorIfPresent MessageAuthenticatorPolicy = iota
+	// Access-Requests and Status-Server packets without a valid
+	// attribute are dropped
+	MessageAuthenticatorRequire
+	// Never check the attribute
+	MessageAuthenticatorOff
+)
+
+// findRawAttribute returns the offset of the first attribute of attrType in
+// an encoded packet, or -1.
+func findRawAttribute(rawMsg []byte, attrType uint8) int {
+
+	for i := headerEnd; i+2 <= len(rawMsg); {
+
+		attrLength := int(rawMsg[i+1])
+		if attrLength < 2 || i+attrLength > len(rawMsg) {
+			return -1
+		}
+
+		if rawMsg[i] == attrType {
+			return i
+		}
+
+		i += attrLength
+	}
+
+	return -1
+}
+
+// calculateMessageAuthenticator returns the HMAC-MD5 of rawMsg with the
+// authenticator field replaced by authenticator and the value of the
+// Message-Authenticator at offset zeroed.
+func calculateMessageAuthenticator(rawMsg []byte, offset int, authenticator []byte, secret string) []byte {
+
+	msg := make([]byte, len(rawMsg))
+	copy(msg, rawMsg)
+	copy(msg[4:headerEnd], authenticator)
+	copy(msg[offset+2:offset+2+messageAuthenticatorLength], ZeroedAuthenticator[:])
+
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(msg)
+
+	return mac.Sum(nil)
+}
+
+// messageAuthenticatorOffset is like findRawAttribute but also makes sure
+// the attribute has the right length.
+func messageAuthenticatorOffset(rawMsg []byte) int {
+
+	offset := findRawAttribute(rawMsg, MessageAuthenticator)
+	if offset < 0 || int(rawMsg[offset+1]) != messageAuthenticatorLength+2 {
+		return -1
+	}
+
+	return offset
+}
+
+// VerifyMessageAuthenticator checks the Message-Authenticator of an encoded
+// packet. For responses requestAuthenticator must be the authenticator of the
+// request, for requests it is ignored. present is false if the packet has no
+// Message-Authenticator.
+func VerifyMessageAuthenticator(rawMsg []byte, requestAuthenticator []byte, secret string) (present, valid bool) {
+
+	offset := findRawAttribute(rawMsg, MessageAuthenticator)
+	if offset < 0 {
+		return false, false
+	}
+
+	if int(rawMsg[offset+1]) != messageAuthenticatorLength+2 {
+		return true, false
+	}
+
+	authenticator := signingAuthenticator(rawMsg, requestAuthenticator)
+	expected := calculateMessageAuthenticator(rawMsg, offset, authenticator, secret)
+	received := rawMsg[offset+2 : offset+2+messageAuthenticatorLength]
+
+	return true, hmac.Equal(expected, received)
+}
+
+// SignMessageAuthenticator fills in the Message-Authenticator of an encoded
+// packet, if it has one. It has to run before the Response or Request
+// Authenticator is calculated since those hash the whole packet.
+func SignMessageAuthenticator(rawMsg []byte, requestAuthenticator []byte, secret string) {
+
+	offset := messageAuthenticatorOffset(rawMsg)
+	if offset < 0 {
+		return
+	}
+
+	authenticator := signingAuthenticator(rawMsg, requestAuthenticator)
+	sum := calculateMessageAuthenticator(rawMsg, offset, authenticator, secret)
+	copy(rawMsg[offset+2:], sum)
+}
+
+// signingAuthenticator returns the value the authenticator field must have
+// while the Message-Authenticator is calculated: the request's own for
+// Access-Request and Status-Server, zeroes for accounting style requests and
+// the request's authenticator for responses.
+func signingAuthenticator(rawMsg []byte, requestAuthenticator []byte) []byte {
+
+	code := rawMsg[0]
+
+	switch {
+	case hasHashedAuthenticator(code):
+		return ZeroedAuthenticator[:]
+	case isResponseCode(code):
+		return requestAuthenticator
+	}
+
+	return rawMsg[4:headerEnd]
+}
+
+// requiresMessageAuthenticator reports whether the server must drop a
+// request from client that has no Message-Authenticator.
+func requiresMessageAuthenticator(client *RadiusClient, code uint8) bool {
+
+	if client.MessageAuthenticator != MessageAuthenticatorRequire {
+		return false
+	}
+
+	return code == AccessRequest || code == StatusServer
+}
@@ -0,0 +1,52 @@
+package goradius
+
+import (
+	"bytes"
+	"testing"
+)
+
+// rfc5997StatusServer is the Status-Server of RFC 5997 §6.1, signed with the
+// secret "xyzzy5461".
+var rfc5997StatusServer = unhex("0cda00268a54f4686fb394c52866e302185d0623" +
+	"50125a665e2e1e8411f3e243822097c84fa3")
+
+func TestMessageAuthenticatorVector(t *testing.T) {
+
+	present, valid := VerifyMessageAuthenticator(rfc5997StatusServer, nil, "xyzzy5461")
+	if !present || !valid {
+		t.Errorf("got present %v valid %v, want a valid Message-Authenticator", present, valid)
+	}
+
+	if _, valid := VerifyMessageAuthenticator(rfc5997StatusServer, nil, "xyzzy5462"); valid {
+		t.Errorf("valid with the wrong secret")
+	}
+
+	tampered := append([]byte{}, rfc5997StatusServer...)
+	tampered[4] ^= 1
+	if _, valid := VerifyMessageAuthenticator(tampered, nil, "xyzzy5461"); valid {
+		t.Errorf("valid with a changed Request Authenticator")
+	}
+
+	signed := append([]byte{}, rfc5997StatusServer...)
+	copy(signed[headerEnd+2:], make([]byte, messageAuthenticatorLength))
+	SignMessageAuthenticator(signed, nil, "xyzzy5461")
+	if !bytes.Equal(signed, rfc5997StatusServer) {
+		t.Errorf("signed\n%x, want\n%x", signed, rfc5997StatusServer)
+	}
+}
+
+func TestMessageAuthenticatorMalformed(t *testing.T) {
+
+	short := append([]byte{}, rfc5997StatusServer[:headerEnd]...)
+	short = append(short, MessageAuthenticator, 6, 0, 0, 0, 0)
+	short[3] = byte(len(short))
+
+	present, valid := VerifyMessageAuthenticator(short, nil, "xyzzy5461")
+	if !present || valid {
+		t.Errorf("got present %v valid %v for a 4 octet Message-Authenticator", present, valid)
+	}
+
+	if present, _ := VerifyMessageAuthenticator(rfc5997StatusServer[:headerEnd], nil, "xyzzy5461"); present {
+		t.Errorf("Message-Authenticator found in a bare header")
+	}
+}
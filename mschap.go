@@ -0,0 +1,374 @@
+package goradius
+
+import (
+	"bytes"
+	"context"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// VendorMicrosoft is the vendor of the MS-CHAP attributes (RFC 2548).
+const VendorMicrosoft = uint32(311)
+
+// Microsoft attributes used by MS-CHAP
+const (
+	msCHAPResponse         = uint32(1)
+	msCHAPError            = uint32(2)
+	msMPPEEncryptionPolicy = uint32(7)
+	msMPPEEncryptionTypes  = uint32(8)
+	msCHAPChallenge        = uint32(11)
+	msCHAPMPPEKeys         = uint32(12)
+	msMPPESendKey          = uint32(16)
+	msMPPERecvKey          = uint32(17)
+	msCHAP2Response        = uint32(25)
+	msCHAP2Success         = uint32(26)
+)
+
+const (
+	msCHAPResponseLength  = 50
+	msCHAPUseNTResponse   = 0x01
+	msCHAPv1ChallengeSize = 8
+	msCHAPv2ChallengeSize = 16
+	msMPPEPolicyAllowed   = 1 // Encryption-Allowed
+	msMPPETypesAllowed    = 6 // RC4-40or128-bit-Allowed
+)
+
+// magic strings of RFC 2759 §8.7 and RFC 3079 §3.4
+var (
+	authenticatorMagic1 = []byte("Magic server to client signing constant")
+	authenticatorMagic2 = []byte("Pad to make it do more than one iteration")
+	mppeMasterKeyMagic  = []byte("This is the MPPE Master Key")
+	mppeReceiveKeyMagic = []byte("On the client side, this is the send key; on the server side, it is the receive key.")
+	mppeSendKeyMagic    = []byte("On the client side, this is the receive key; on the server side, it is the send key.")
+)
+
+func microsoftAttribute(vendorType uint32) AttributeID {
+	return AttributeID{VendorMicrosoft, []uint32{vendorType}}
+}
+
+// NTHashSource gives the NT hash of the password of users, which is all
+// MS-CHAP needs to check their credentials.
+type NTHashSource interface {
+	NTHash(ctx context.Context, username string) ([16]byte, error)
+}
+
+// NTHashSourceFunc lets an ordinary function be used as an NTHashSource.
+type NTHashSourceFunc func(ctx context.Context, username string) ([16]byte, error)
+
+func (f NTHashSourceFunc) NTHash(ctx context.Context, username string) ([16]byte, error) {
+	return f(ctx, username)
+}
+
+// NTHashes adapts a source of clear text passwords to an NTHashSource.
+func NTHashes(source PasswordSource) NTHashSource {
+	return NTHashSourceFunc(func(ctx context.Context, username string) ([16]byte, error) {
+
+		password, err := source.Password(ctx, username)
+		if err != nil {
+			return [16]byte{}, err
+		}
+
+		return NTPasswordHash(string(password)), nil
+	})
+}
+
+// NTPasswordHash returns the NT hash of password, the MD4 of its UTF-16
+// little endian encoding.
+func NTPasswordHash(password string) [16]byte {
+
+	var unicode []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		unicode = binary.LittleEndian.AppendUint16(unicode, c)
+	}
+
+	return md4Sum(unicode)
+}
+
+// desKey spreads 7 octets of key over the 8 DES wants, crypto/des ignores
+// the parity bits.
+func desKey(k []byte) []byte {
+	return []byte{
+		k[0],
+		k[0]<<7 | k[1]>>1,
+		k[1]<<6 | k[2]>>2,
+		k[2]<<5 | k[3]>>3,
+		k[3]<<4 | k[4]>>4,
+		k[4]<<3 | k[5]>>5,
+		k[5]<<2 | k[6]>>6,
+		k[6] << 1,
+	}
+}
+
+// challengeResponse is ChallengeResponse of RFC 2759 §8.5: the 8 octet
+// challenge encrypted with DES by three keys taken from the zero padded
+// hash.
+func challengeResponse(challenge []byte, hash [16]byte) []byte {
+
+	var keys [21]byte
+	copy(keys[:], hash[:])
+
+	response := make([]byte, 24)
+	for i := 0; i < 3; i++ {
+		block, err := des.NewCipher(desKey(keys[7*i : 7*i+7]))
+		if err != nil {
+			panic(err)
+		}
+		block.Encrypt(response[8*i:], challenge)
+	}
+
+	return response
+}
+
+// challengeHash is ChallengeHash of RFC 2759 §8.2.
+func challengeHash(peerChallenge, authenticatorChallenge []byte, username string) []byte {
+
+	hash := sha1.New()
+	hash.Write(peerChallenge)
+	hash.Write(authenticatorChallenge)
+	hash.Write([]byte(username))
+
+	return hash.Sum(nil)[:8]
+}
+
+// authenticatorResponse is GenerateAuthenticatorResponse of RFC 2759 §8.7,
+// the "S=" string that proves to the peer that the server knows its
+// password.
+func authenticatorResponse(ntHash [16]byte, ntResponse, challenge []byte) string {
+
+	hashHash := md4Sum(ntHash[:])
+
+	hash := sha1.New()
+	hash.Write(hashHash[:])
+	hash.Write(ntResponse)
+	hash.Write(authenticatorMagic1)
+	digest := hash.Sum(nil)
+
+	hash = sha1.New()
+	hash.Write(digest)
+	hash.Write(challenge)
+	hash.Write(authenticatorMagic2)
+
+	return "S=" + strings.ToUpper(hex.EncodeToString(hash.Sum(nil)))
+}
+
+// mppeKeys derives the 128 bit MPPE keys of the server from an MS-CHAPv2
+// authentication (RFC 3079 §3).
+func mppeKeys(ntHash [16]byte, ntResponse []byte) (send, receive []byte) {
+
+	hashHash := md4Sum(ntHash[:])
+
+	hash := sha1.New()
+	hash.Write(hashHash[:])
+	hash.Write(ntResponse)
+	hash.Write(mppeMasterKeyMagic)
+	masterKey := hash.Sum(nil)[:16]
+
+	return mppeStartKey(masterKey, mppeSendKeyMagic), mppeStartKey(masterKey, mppeReceiveKeyMagic)
+}
+
+// mppeStartKey is GetAsymmetricStartKey of RFC 3079 §3.4.
+func mppeStartKey(masterKey, magic []byte) []byte {
+
+	hash := sha1.New()
+	hash.Write(masterKey)
+	hash.Write(bytes.Repeat([]byte{0x00}, 40))
+	hash.Write(magic)
+	hash.Write(bytes.Repeat([]byte{0xf2}, 40))
+
+	return hash.Sum(nil)[:16]
+}
+
+// stripDomain returns the user part of a DOMAIN\user name, which is what
+// the MS-CHAPv2 challenge hash is calculated with.
+func stripDomain(username string) string {
+	return username[strings.LastIndex(username, `\`)+1:]
+}
+
+func firstValue(p *RadiusPacket, id AttributeID) []byte {
+
+	values := p.GetAttributeByID(id)
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values[0]
+}
+
+func uint32Value(n uint32) []byte {
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, n)
+
+	return value
+}
+
+// isMSCHAP reports whether req carries an MS-CHAP authentication.
+func isMSCHAP(req *RadiusPacket) bool {
+
+	if firstValue(req, microsoftAttribute(msCHAPChallenge)) == nil {
+		return false
+	}
+
+	return firstValue(req, microsoftAttribute(msCHAP2Response)) != nil ||
+		firstValue(req, microsoftAttribute(msCHAPResponse)) != nil
+}
+
+// VerifyMSCHAP checks the MS-CHAP-Response (RFC 2433) or MS-CHAP2-Response
+// (RFC 2759) of req against the NT hash of the user's password. On success
+// the MPPE keys, and for MS-CHAPv2 the MS-CHAP2-Success, are added to res,
+// otherwise an MS-CHAP-Error is.
+func VerifyMSCHAP(req, res *RadiusPacket, ntHash [16]byte) bool {
+
+	challenge := firstValue(req, microsoftAttribute(msCHAPChallenge))
+
+	if response := firstValue(req, microsoftAttribute(msCHAP2Response)); response != nil {
+		return verifyMSCHAPv2(req, res, challenge, response, ntHash)
+	}
+
+	if response := firstValue(req, microsoftAttribute(msCHAPResponse)); response != nil {
+		return verifyMSCHAPv1(res, challenge, response, ntHash)
+	}
+
+	return false
+}
+
+// msCHAPIdent returns the Ident of the MS-CHAP response of req, which the
+// MS-CHAP-Error answering it repeats.
+func msCHAPIdent(req *RadiusPacket) uint8 {
+
+	response := firstValue(req, microsoftAttribute(msCHAP2Response))
+	if response == nil {
+		response = firstValue(req, microsoftAttribute(msCHAPResponse))
+	}
+
+	if len(response) == 0 {
+		return 0
+	}
+
+	return response[0]
+}
+
+func verifyMSCHAPv1(res *RadiusPacket, challenge, response []byte, ntHash [16]byte) bool {
+
+	// only the NT response is checked, LM responses are too weak to accept
+	if len(challenge) != msCHAPv1ChallengeSize || len(response) != msCHAPResponseLength ||
+		response[1]&msCHAPUseNTResponse == 0 {
+		if len(response) > 0 {
+			addMSCHAPError(res, response[0])
+		}
+		return false
+	}
+
+	expected := challengeResponse(challenge, ntHash)
+	if subtle.ConstantTimeCompare(expected, response[26:50]) != 1 {
+		addMSCHAPError(res, response[0])
+		return false
+	}
+
+	// the LM key is left empty, only the NT hash is known. Like most
+	// servers the NT key is the hash of the NT hash.
+	hashHash := md4Sum(ntHash[:])
+	keys := append(make([]byte, 8), hashHash[:]...)
+
+	res.AddAttributeByID(microsoftAttribute(msCHAPMPPEKeys), keys)
+	addMPPEPolicy(res)
+
+	return true
+}
+
+func verifyMSCHAPv2(req, res *RadiusPacket, challenge, response []byte, ntHash [16]byte) bool {
+
+	if len(challenge) != msCHAPv2ChallengeSize || len(response) != msCHAPResponseLength {
+		if len(response) > 0 {
+			addMSCHAPError(res, response[0])
+		}
+		return false
+	}
+
+	ident := response[0]
+	ntResponse := response[26:50]
+	username := req.GetFirstAttributeAsString("User-Name")
+
+	authenticator, ok := checkMSCHAPv2(challenge, response[2:18], ntResponse, username, ntHash)
+	if !ok {
+		addMSCHAPError(res, ident)
+		return false
+	}
+
+	success := append([]byte{ident}, authenticator...)
+	res.AddAttributeByID(microsoftAttribute(msCHAP2Success), success)
+
+	send, receive := mppeKeys(ntHash, ntResponse)
+	res.AddAttributeByID(microsoftAttribute(msMPPESendKey), send)
+	res.AddAttributeByID(microsoftAttribute(msMPPERecvKey), receive)
+	addMPPEPolicy(res)
+
+	return true
+}
+
+// checkMSCHAPv2 checks an MS-CHAPv2 NT-Response and returns the
+// authenticator response to send back, whatever carries them.
+func checkMSCHAPv2(challenge, peerChallenge, ntResponse []byte, username string, ntHash [16]byte) (string, bool) {
+
+	hash := challengeHash(peerChallenge, challenge, stripDomain(username))
+
+	expected := challengeResponse(hash, ntHash)
+	if subtle.ConstantTimeCompare(expected, ntResponse) != 1 {
+		return "", false
+	}
+
+	return authenticatorResponse(ntHash, ntResponse, hash), true
+}
+
+// addMSCHAPError tells the peer that authentication failed and that it
+// shouldn't retry (RFC 2759 §6).
+func addMSCHAPError(res *RadiusPacket, ident uint8) {
+	res.AddAttributeByID(microsoftAttribute(msCHAPError), append([]byte{ident}, "E=691 R=0"...))
+}
+
+func addMPPEPolicy(res *RadiusPacket) {
+	res.AddAttributeByID(microsoftAttribute(msMPPEEncryptionPolicy), uint32Value(msMPPEPolicyAllowed))
+	res.AddAttributeByID(microsoftAttribute(msMPPEEncryptionTypes), uint32Value(msMPPETypesAllowed))
+}
+
+// MSCHAPAuth checks the Access-Requests carrying an MS-CHAP or MS-CHAPv2
+// response against the NT hashes of source, use NTHashes for a source of
+// clear text passwords. Users with valid credentials are accepted, get
+// their MPPE keys and go on to next. Others are rejected right away.
+// Requests without MS-CHAP go to next untouched, and errors of source other
+// than ErrUnknownUser drop the request.
+func MSCHAPAuth(source NTHashSource) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+
+			if req.Code != AccessRequest || !isMSCHAP(req) {
+				return next.ServeRADIUS(ctx, w, req)
+			}
+
+			ntHash, err := source.NTHash(ctx, req.GetFirstAttributeAsString("User-Name"))
+			if err != nil && !errors.Is(err, ErrUnknownUser) {
+				return err
+			}
+
+			if errors.Is(err, ErrUnknownUser) {
+				addMSCHAPError(w.Response(), msCHAPIdent(req))
+				w.Response().Code = AccessReject
+				return nil
+			}
+
+			if !VerifyMSCHAP(req, w.Response(), ntHash) {
+				w.Response().Code = AccessReject
+				return nil
+			}
+
+			w.Response().Code = AccessAccept
+			return next.ServeRADIUS(ctx, w, req)
+		})
+	}
+}
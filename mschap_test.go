@@ -0,0 +1,215 @@
+package goradius
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func unhex(s string) []byte {
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// RFC 1320 appendix A.5
+func TestMD4(t *testing.T) {
+
+	tests := map[string]string{
+		"":                           "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":                          "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":                        "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest":             "d9130a8164549fe818874806e1c7014b",
+		"abcdefghijklmnopqrstuvwxyz": "d79e1c308aa5bbcdeea8ed63df412da9",
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789": "043f8582f241db351ce627e153e7f0e4",
+		strings.Repeat("1234567890", 8):                                  "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+
+	for input, want := range tests {
+		if got := md4Sum([]byte(input)); hex.EncodeToString(got[:]) != want {
+			t.Errorf("md4(%q) = %x, want %v", input, got, want)
+		}
+	}
+}
+
+// RFC 2759 §9.2
+var (
+	rfc2759Username               = "User"
+	rfc2759Password               = "clientPass"
+	rfc2759AuthenticatorChallenge = unhex("5B5D7C7D7B3F2F3E3C2C602132262628")
+	rfc2759PeerChallenge          = unhex("21402324255E262A28295F2B3A337C7E")
+	rfc2759NTResponse             = unhex("82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF")
+)
+
+func TestMSCHAPv2RFC2759(t *testing.T) {
+
+	ntHash := NTPasswordHash(rfc2759Password)
+	if want := unhex("44EBBA8D5312B8D611474411F56989AE"); string(ntHash[:]) != string(want) {
+		t.Errorf("NT hash %x, want %x", ntHash, want)
+	}
+
+	hash := challengeHash(rfc2759PeerChallenge, rfc2759AuthenticatorChallenge, rfc2759Username)
+	if want := unhex("D02E4386BCE91226"); string(hash) != string(want) {
+		t.Errorf("challenge hash %x, want %x", hash, want)
+	}
+
+	if got := challengeResponse(hash, ntHash); string(got) != string(rfc2759NTResponse) {
+		t.Errorf("NT response %x, want %x", got, rfc2759NTResponse)
+	}
+
+	if hashHash := md4Sum(ntHash[:]); string(hashHash[:]) != string(unhex("41C00C584BD2D91C4017A2A12FA59F3F")) {
+		t.Errorf("password hash hash %x", hashHash)
+	}
+
+	authenticator, ok := checkMSCHAPv2(rfc2759AuthenticatorChallenge, rfc2759PeerChallenge, rfc2759NTResponse, `DOMAIN\User`, ntHash)
+	if want := "S=407A5589115FD0D6209F510FE9C04566932CDA56"; !ok || authenticator != want {
+		t.Errorf("got %v %v, want %v", authenticator, ok, want)
+	}
+}
+
+// RFC 3079 §3.5.3, the 128 bit send key of the server derived from the
+// RFC 2759 example.
+func TestMPPEKeysRFC3079(t *testing.T) {
+
+	send, receive := mppeKeys(NTPasswordHash(rfc2759Password), rfc2759NTResponse)
+
+	if want := unhex("8B7CDC149B993A1BA118CB153F56DCCB"); string(send) != string(want) {
+		t.Errorf("send key %x, want %x", send, want)
+	}
+	if len(receive) != 16 || string(send) == string(receive) {
+		t.Errorf("receive key %x", receive)
+	}
+}
+
+// RFC 2433 appendix B.2
+func TestMSCHAPv1RFC2433(t *testing.T) {
+
+	ntHash := NTPasswordHash("MyPw")
+	if want := unhex("FC156AF7EDCD6C0EDDE3337D427F4EAC"); string(ntHash[:]) != string(want) {
+		t.Errorf("NT hash %x, want %x", ntHash, want)
+	}
+
+	got := challengeResponse(unhex("102DB5DF085D3041"), ntHash)
+	if want := unhex("4E9D3C8F9CFD385D5BF4D3246791956CA4C351AB409A3D61"); string(got) != string(want) {
+		t.Errorf("NT response %x, want %x", got, want)
+	}
+}
+
+func msCHAPv2Request(username string, ntResponse []byte) *RadiusPacket {
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.AddAttribute("User-Name", []byte(username))
+	req.AddAttributeByID(microsoftAttribute(msCHAPChallenge), rfc2759AuthenticatorChallenge)
+
+	response := []byte{7, 0}
+	response = append(response, rfc2759PeerChallenge...)
+	response = append(response, make([]byte, 8)...)
+	response = append(response, ntResponse...)
+	req.AddAttributeByID(microsoftAttribute(msCHAP2Response), response)
+
+	return req
+}
+
+func TestMSCHAPAuth(t *testing.T) {
+
+	source := NTHashSourceFunc(func(ctx context.Context, username string) ([16]byte, error) {
+		switch username {
+		case rfc2759Username:
+			return NTPasswordHash(rfc2759Password), nil
+		case "moved":
+			return [16]byte{}, fmt.Errorf("directory lookup: %w", ErrUnknownUser)
+		}
+		return [16]byte{}, ErrUnknownUser
+	})
+
+	handler := MSCHAPAuth(source)(HandlerFunc(func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		return nil
+	}))
+
+	tests := []struct {
+		name string
+		req  *RadiusPacket
+		code uint8
+	}{
+		{"valid", msCHAPv2Request(rfc2759Username, rfc2759NTResponse), AccessAccept},
+		{"wrong response", msCHAPv2Request(rfc2759Username, make([]byte, 24)), AccessReject},
+		{"short response", msCHAPv2Request(rfc2759Username, rfc2759NTResponse[:20]), AccessReject},
+		{"unknown user", msCHAPv2Request("nobody", rfc2759NTResponse), AccessReject},
+		{"wrapped unknown user", msCHAPv2Request("moved", rfc2759NTResponse), AccessReject},
+	}
+
+	for _, tt := range tests {
+
+		w := &responseWriter{response: NewRadiusPacket()}
+		if err := handler.ServeRADIUS(context.Background(), w, tt.req); err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+
+		res := w.Response()
+		if res.Code != tt.code {
+			t.Errorf("%v: got code %v, want %v", tt.name, res.Code, tt.code)
+		}
+
+		msError := firstValue(res, microsoftAttribute(msCHAPError))
+		if tt.code == AccessReject && string(msError) != "\x07E=691 R=0" {
+			t.Errorf("%v: got MS-CHAP-Error %q", tt.name, msError)
+		}
+
+		if tt.code == AccessAccept {
+			success := firstValue(res, microsoftAttribute(msCHAP2Success))
+			if string(success) != "\x07S=407A5589115FD0D6209F510FE9C04566932CDA56" {
+				t.Errorf("%v: got MS-CHAP2-Success %q", tt.name, success)
+			}
+			if firstValue(res, microsoftAttribute(msMPPESendKey)) == nil {
+				t.Errorf("%v: no MPPE keys", tt.name)
+			}
+		}
+	}
+}
+
+func TestMSCHAPv1Errors(t *testing.T) {
+
+	ntHash := NTPasswordHash("MyPw")
+
+	request := func(flags uint8, ntResponse []byte) *RadiusPacket {
+		req := NewRadiusPacket()
+		req.Code = AccessRequest
+		req.AddAttributeByID(microsoftAttribute(msCHAPChallenge), unhex("102DB5DF085D3041"))
+		response := append([]byte{3, flags}, make([]byte, 24)...)
+		req.AddAttributeByID(microsoftAttribute(msCHAPResponse), append(response, ntResponse...))
+		return req
+	}
+	valid := unhex("4E9D3C8F9CFD385D5BF4D3246791956CA4C351AB409A3D61")
+
+	tests := []struct {
+		name string
+		req  *RadiusPacket
+		ok   bool
+	}{
+		{"valid", request(1, valid), true},
+		{"LM only", request(0, valid), false},
+		{"short", request(1, valid[:10]), false},
+		{"wrong", request(1, make([]byte, 24)), false},
+	}
+
+	for _, tt := range tests {
+		res := NewRadiusPacket()
+		if ok := VerifyMSCHAP(tt.req, res, ntHash); ok != tt.ok {
+			t.Errorf("%v: got %v", tt.name, ok)
+		}
+		msError := firstValue(res, microsoftAttribute(msCHAPError))
+		if !tt.ok && string(msError) != "\x03E=691 R=0" {
+			t.Errorf("%v: got MS-CHAP-Error %q", tt.name, msError)
+		}
+		if tt.ok && firstValue(res, microsoftAttribute(msCHAPMPPEKeys)) == nil {
+			t.Errorf("%v: no MS-CHAP-MPPE-Keys", tt.name)
+		}
+	}
+}
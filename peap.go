@@ -0,0 +1,153 @@
+package goradius
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+)
+
+// EAP-TLV, which carries the result of PEAP
+const eapTypeTLV = uint8(33)
+
+// operation codes of EAP-MSCHAPv2 (draft-kamath-pppext-eap-mschapv2)
+const (
+	eapMSCHAPv2Challenge = uint8(1)
+	eapMSCHAPv2Response  = uint8(2)
+	eapMSCHAPv2Success   = uint8(3)
+)
+
+const eapMSCHAPv2ServerName = "goradius"
+
+// Result TLV, mandatory, 2 octets of Success
+var peapResultSuccess = []byte{0x80, 0x03, 0x00, 0x02, 0x00, 0x01}
+
+var errPEAPProtocol = errors.New("Unexpected message in PEAP tunnel.")
+
+// PEAP returns PEAPv0, in which peers authenticate with EAP-MSCHAPv2 inside
+// a tunnel set up with config. Passwords are checked against the NT hashes
+// of source.
+func PEAP(config *tls.Config, source NTHashSource) NewEAPMethod {
+
+	config = tlsServerConfig(config)
+
+	return func() EAPMethod {
+		return &tlsMethod{
+			config:  config,
+			label:   "client EAP encryption",
+			program: peapProgram(source),
+		}
+	}
+}
+
+// peapInner returns the Type and Type-Data of an inner EAP-Response. PEAPv0
+// leaves the EAP header out of them, but some peers send it anyway.
+func peapInner(b []byte) []byte {
+
+	if len(b) > eapHeaderLength && b[0] == EAPResponse && int(binary.BigEndian.Uint16(b[2:4])) == len(b) {
+		return b[eapHeaderLength:]
+	}
+
+	return b
+}
+
+// eapMSCHAPv2Packet returns the Type and Type-Data of an EAP-MSCHAPv2
+// request, the MS-Length covers everything from the operation code.
+func eapMSCHAPv2Packet(opCode, id uint8, data []byte) []byte {
+
+	b := []byte{EAPTypeMSCHAPv2, opCode, id}
+	b = binary.BigEndian.AppendUint16(b, uint16(4+len(data)))
+
+	return append(b, data...)
+}
+
+func peapProgram(source NTHashSource) func(s *EAPSession, t *tlsTunnel) error {
+	return func(s *EAPSession, t *tlsTunnel) error {
+
+		// the peer acknowledges the end of the handshake
+		if ack, err := t.receive(); err != nil || ack != nil {
+			return errPEAPProtocol
+		}
+
+		if err := t.send([]byte{EAPTypeIdentity}); err != nil {
+			return err
+		}
+
+		data, err := t.receive()
+		if err != nil {
+			return err
+		}
+		response := peapInner(data)
+		if len(response) < 1 || response[0] != EAPTypeIdentity {
+			return errPEAPProtocol
+		}
+		identity := string(response[1:])
+
+		ntHash, err := source.NTHash(t.context(), identity)
+		if errors.Is(err, ErrUnknownUser) {
+			return errInnerAuthentication
+		}
+		if err != nil {
+			return err
+		}
+
+		id := s.Identifier
+		challenge := randomBytes(msCHAPv2ChallengeSize)
+		value := append([]byte{msCHAPv2ChallengeSize}, challenge...)
+		value = append(value, eapMSCHAPv2ServerName...)
+		if err := t.send(eapMSCHAPv2Packet(eapMSCHAPv2Challenge, id, value)); err != nil {
+			return err
+		}
+
+		// Type, OpCode, ID, MS-Length, Value-Size, Peer-Challenge, Reserved,
+		// NT-Response, Flags and Name
+		data, err = t.receive()
+		if err != nil {
+			return err
+		}
+		response = peapInner(data)
+		if len(response) < 55 || response[0] != EAPTypeMSCHAPv2 || response[1] != eapMSCHAPv2Response ||
+			response[2] != id || response[5] != 49 {
+			return errPEAPProtocol
+		}
+		name := string(response[55:])
+
+		authenticator, ok := checkMSCHAPv2(challenge, response[6:22], response[30:54], name, ntHash)
+		if !ok {
+			return errInnerAuthentication
+		}
+
+		success := []byte(authenticator + " M=Authentication succeeded")
+		if err := t.send(eapMSCHAPv2Packet(eapMSCHAPv2Success, id, success)); err != nil {
+			return err
+		}
+
+		data, err = t.receive()
+		if err != nil {
+			return err
+		}
+		response = peapInner(data)
+		if len(response) < 2 || response[0] != EAPTypeMSCHAPv2 || response[1] != eapMSCHAPv2Success {
+			return errPEAPProtocol
+		}
+
+		// EAP-TLV packets keep their header
+		result := EAPPacket{Code: EAPRequest, Identifier: id + 1, Type: eapTypeTLV, Data: peapResultSuccess}
+		if err := t.send(result.Bytes()); err != nil {
+			return err
+		}
+
+		data, err = t.receive()
+		if err != nil {
+			return err
+		}
+		packet, err := ParseEAPPacket(data)
+		if err != nil || packet.Code != EAPResponse || packet.Type != eapTypeTLV ||
+			string(packet.Data) != string(peapResultSuccess) {
+			return errInnerAuthentication
+		}
+
+		s.Identity = identity
+
+		return nil
+	}
+}
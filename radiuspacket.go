@@ -5,8 +5,8 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 )
@@ -27,15 +27,32 @@ type RadiusAttribute struct {
 	Type         uint8
 	Length       uint8
 	VendorId     uint32
-	VendorType   uint8
+	VendorType   uint32
 	VendorLength uint8
 	Value        []byte
+
+	// Path holds the attribute numbers below Type, or below VendorType for
+	// vendor attributes: the Extended-Type of RFC 6929 attributes and the
+	// numbers of nested TLVs.
+	Path []uint32
+
+	// Tag of the attributes the dictionary flags has_tag (RFC 2868), taken
+	// out of Value.
+	Tag uint8
 }
 
 type RadiusPacket struct {
 	RadiusHeader
 	Attributes []RadiusAttribute
 	Addr       *net.UDPAddr
+	Client     *RadiusClient
+
+	// Dictionary resolves attribute names, DefaultDictionary when nil
+	Dictionary *Dictionary
+
+	// PackVendorAttributes sends vendor attributes of the same vendor that
+	// follow each other in a single Vendor-Specific when they fit.
+	PackVendorAttributes bool
 }
 
 type VendorSpecificAttribute struct {
@@ -82,7 +99,52 @@ func (r RadiusAttribute) Bytes() []byte {
 }
 
 func (r RadiusAttribute) String() string {
-	return fmt.Sprintf("%v: %x", code_to_attributes[r.Type], r.Value)
+	return DefaultDictionary.FormatAttribute(r)
+}
+
+// FormatAttribute prints r using the names, types and values of d, or of
+// DefaultDictionary for attributes d doesn't know.
+func (d *Dictionary) FormatAttribute(r RadiusAttribute) string {
+
+	for _, dict := range []*Dictionary{d, DefaultDictionary} {
+		if def, ok := dict.attributeOf(r); ok {
+			if r.Tag != 0 {
+				return fmt.Sprintf("%v:%v: %v", def.Name, r.Tag, formatValue(def, r.Value))
+			}
+			return fmt.Sprintf("%v: %v", def.Name, formatValue(def, r.Value))
+		}
+	}
+
+	if name, ok := code_to_attributes[r.Type]; ok && r.VendorId == 0 && len(r.Path) == 0 {
+		return fmt.Sprintf("%v: %x", name, r.Value)
+	}
+
+	return fmt.Sprintf("%v: %x", r.ID(), r.Value)
+}
+
+// encryption returns how the value of r is encrypted on the wire.
+func (d *Dictionary) encryption(r RadiusAttribute) int {
+
+	if def, ok := d.attributeOf(r); ok {
+		return def.Encrypt
+	}
+
+	if r.Type == UserPassword {
+		return EncryptUserPassword
+	}
+
+	// keys of MS-CHAP, which must not go in clear text when the Microsoft
+	// dictionary isn't loaded
+	if r.VendorId == VendorMicrosoft && len(r.Path) == 0 {
+		switch r.VendorType {
+		case msCHAPMPPEKeys:
+			return EncryptUserPassword
+		case msMPPESendKey, msMPPERecvKey:
+			return EncryptTunnel
+		}
+	}
+
+	return EncryptNone
 }
 
 /*
@@ -95,8 +157,33 @@ func NewRadiusPacket() *RadiusPacket {
 
 }
 
+// NewPacket returns a packet that uses d.
+func (d *Dictionary) NewPacket() *RadiusPacket {
+
+	p := NewRadiusPacket()
+	p.Dictionary = d
+
+	return p
+}
+
 func (r RadiusPacket) String() string {
-	return fmt.Sprintf("RadiusPacket{%v %v}", r.RadiusHeader, r.Attributes)
+
+	d := r.dictionary()
+	attrs := make([]string, len(r.Attributes))
+	for i, attr := range r.Attributes {
+		attrs[i] = d.FormatAttribute(attr)
+	}
+
+	return fmt.Sprintf("RadiusPacket{%v %v}", r.RadiusHeader, attrs)
+}
+
+func (r *RadiusPacket) dictionary() *Dictionary {
+
+	if r.Dictionary == nil {
+		return DefaultDictionary
+	}
+
+	return r.Dictionary
 }
 
 func (r *RadiusPacket) Duplicate() *RadiusPacket {
@@ -106,6 +193,8 @@ func (r *RadiusPacket) Duplicate() *RadiusPacket {
 	dest.Identifier = r.Identifier
 	dest.Length = r.Length
 	dest.Authenticator = r.Authenticator
+	dest.Dictionary = r.Dictionary
+	dest.PackVendorAttributes = r.PackVendorAttributes
 
 	for _, attr := range r.Attributes {
 		dest.Attributes = append(dest.Attributes, attr)
@@ -114,30 +203,87 @@ func (r *RadiusPacket) Duplicate() *RadiusPacket {
 	return &dest
 }
 
-func (p *RadiusPacket) AddAttribute(attrTypeStr string, value []byte) error {
+// ProxyRequest returns a copy of the request r to forward to another server.
+// Access-Request and Status-Server get a new Request Authenticator, with
+// which User-Password is hidden again, and the Message-Authenticator is left
+// out to be calculated with the other server's secret.
+func (r *RadiusPacket) ProxyRequest() *RadiusPacket {
+
+	dest := r.Duplicate()
+	dest.Length = 0
+	dest.Attributes = nil
 
-	var err error
-	if attrTypeCode, ok := attributes_to_code[attrTypeStr]; ok {
-		attr := RadiusAttribute{
-			Type:  attrTypeCode,
-			Value: value,
+	for _, attr := range r.Attributes {
+		if attr.Type != MessageAuthenticator {
+			dest.Attributes = append(dest.Attributes, attr)
 		}
+	}
 
-		p.Attributes = append(p.Attributes, attr)
-		err = nil
-	} else {
+	if r.Code == AccessRequest || r.Code == StatusServer {
+		dest.Authenticator = GenerateRandomAuthenticator()
+	}
 
-		vsa_attr, err := CreateVSA(attrTypeStr, value)
-		if err != nil {
-			return err
+	return dest
+}
+
+// attributeCode returns the type of the standard attribute called name, as
+// known by d or else by DefaultDictionary.
+func (d *Dictionary) attributeCode(name string) (uint8, bool) {
+
+	if code, ok := attributes_to_code[name]; ok {
+		return code, true
+	}
+
+	for _, dict := range []*Dictionary{d, DefaultDictionary} {
+		if attr, ok := dict.FindAttribute(name); ok && attr.Vendor == nil && len(attr.OID) == 1 {
+			return uint8(attr.Code()), true
 		}
+	}
+
+	return 0, false
+}
+
+// attributeID returns the identifier of the attribute called name.
+func (d *Dictionary) attributeID(name string) (AttributeID, error) {
+
+	if code, ok := d.attributeCode(name); ok {
+		return AttributeID{0, []uint32{uint32(code)}}, nil
+	}
+
+	if attr, ok := d.FindAttribute(name); ok {
+		return attr.ID(), nil
+	}
+
+	vsa, err := d.FindVSA(name)
+	if err != nil {
+		return AttributeID{}, err
+	}
 
-		p.Attributes = append(p.Attributes, vsa_attr)
-		err = nil
+	return AttributeID{vsa.VendorId, []uint32{uint32(vsa.VendorType)}}, nil
+}
+
+func (p *RadiusPacket) AddAttribute(attrTypeStr string, value []byte) error {
 
+	id, err := p.dictionary().attributeID(attrTypeStr)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return p.AddAttributeByID(id, value)
+}
+
+// AddAttributeByID adds the attribute id, which can be nested in extended
+// attributes or TLVs.
+func (p *RadiusPacket) AddAttributeByID(id AttributeID, value []byte) error {
+
+	attr, err := p.dictionary().NewAttribute(id, value)
+	if err != nil {
+		return err
+	}
+
+	p.Attributes = append(p.Attributes, attr)
+
+	return nil
 }
 
 func (p *RadiusPacket) AddAttributeByType(attrType uint8, value []byte) {
@@ -151,48 +297,54 @@ func (p *RadiusPacket) AddAttributeByType(attrType uint8, value []byte) {
 
 }
 
-func (p *RadiusPacket) GetAttribute(attrType string) [][]byte {
+// addMessageAuthenticator adds an empty Message-Authenticator unless the
+// packet already has one. The value is calculated by EncodePacket.
+func (p *RadiusPacket) addMessageAuthenticator() {
 
-	var attrs [][]byte
+	for _, attr := range p.Attributes {
+		if attr.Type == MessageAuthenticator {
+			return
+		}
+	}
 
-	if attrTypeCode, ok := attributes_to_code[attrType]; ok {
-		for _, v := range p.Attributes {
+	p.AddAttributeByType(MessageAuthenticator, make([]byte, messageAuthenticatorLength))
+}
 
-			if v.Type == attrTypeCode {
+func (p *RadiusPacket) GetAttribute(attrType string) [][]byte {
 
-				// if v.Type == VendorSpecific {
-				// 	reader := bytes.NewBuffer(v.Value)
-				// 	vsa := VendorSpecificAttribute{}
-				// 	err := binary.Read(reader, binary.BigEndian, &vsa)
-				// 	if err != nil {
-				// 		log.Fatal(err)
-				// 	}
-				// 	value := reader.Next(int(vsa.VendorLength))
-				// 	attrs = append(attrs, value)
-				// } else {
-				// }
-				attrs = append(attrs, v.Value)
+	id, err := p.dictionary().attributeID(attrType)
+	if err != nil {
+		return nil
+	}
 
-			}
+	return p.GetAttributeByID(id)
+}
 
-		}
-	} else {
-		log.Printf("Looking for VSA")
-		vsa, err := FindVSA(attrType)
-		if err == nil {
-			for _, v := range p.Attributes {
-				if v.Type == VendorSpecific {
-					if vsa.VendorId == v.VendorId && vsa.VendorType == v.VendorType {
-						attrs = append(attrs, v.Value)
-					}
-				}
-			}
+// GetAttributeByID returns the values of every id attribute.
+func (p *RadiusPacket) GetAttributeByID(id AttributeID) [][]byte {
+
+	var attrs [][]byte
+	for _, attr := range p.Attributes {
+		if attr.ID().Equal(id) {
+			attrs = append(attrs, attr.Value)
 		}
 	}
 
 	return attrs
 }
 
+// RemoveAttributeByID deletes every id attribute from the packet.
+func (p *RadiusPacket) RemoveAttributeByID(id AttributeID) {
+
+	kept := p.Attributes[:0]
+	for _, attr := range p.Attributes {
+		if !attr.ID().Equal(id) {
+			kept = append(kept, attr)
+		}
+	}
+	p.Attributes = kept
+}
+
 func (p *RadiusPacket) GetFirstAttribute(attrType string) []byte {
 
 	var attr []byte
@@ -208,29 +360,6 @@ func (p *RadiusPacket) GetFirstAttributeAsString(attrType string) string {
 	return string(p.GetFirstAttribute(attrType))
 }
 
-func encodeVendorSpecificAttr(attr RadiusAttribute) []byte {
-
-	vsa := VendorSpecificAttribute{
-		VendorId:     attr.VendorId,
-		VendorType:   attr.VendorType,
-		VendorLength: uint8(len(attr.Value) + 2),
-	}
-
-	buf := bytes.NewBuffer([]byte{})
-	err := binary.Write(buf, binary.BigEndian, vsa)
-	if err != nil {
-		panic(err)
-	}
-
-	err = binary.Write(buf, binary.BigEndian, attr.Value)
-	if err != nil {
-		panic(err)
-	}
-
-	return buf.Bytes()
-
-}
-
 func VendorAttribute(attrName string, value []byte) RadiusAttribute {
 
 	attr, err := CreateVSA(attrName, value)
@@ -243,8 +372,12 @@ func VendorAttribute(attrName string, value []byte) RadiusAttribute {
 }
 
 func CreateVSA(attrName string, value []byte) (RadiusAttribute, error) {
+	return DefaultDictionary.CreateVSA(attrName, value)
+}
 
-	vsa, err := FindVSA(attrName)
+func (d *Dictionary) CreateVSA(attrName string, value []byte) (RadiusAttribute, error) {
+
+	vsa, err := d.FindVSA(attrName)
 	if err != nil {
 		return RadiusAttribute{}, err
 	}
@@ -252,7 +385,7 @@ func CreateVSA(attrName string, value []byte) (RadiusAttribute, error) {
 	rattr := RadiusAttribute{
 		Type:       uint8(26),
 		VendorId:   vsa.VendorId,
-		VendorType: vsa.VendorType,
+		VendorType: uint32(vsa.VendorType),
 		Value:      value,
 	}
 
@@ -262,137 +395,374 @@ func CreateVSA(attrName string, value []byte) (RadiusAttribute, error) {
 
 }
 
-func (r *RadiusPacket) encodeAttrs(secret string) []byte {
+func (r *RadiusPacket) encodeAttrs(secret string) ([]byte, error) {
 
+	d := r.dictionary()
 	buf := bytes.NewBuffer([]byte{})
 
+	// responses carry the authenticator of the request, which is what
+	// values are hidden with, requests with a hashed one use zeros
+	authenticator := r.Authenticator
+	if hasHashedAuthenticator(r.Code) {
+		authenticator = ZeroedAuthenticator
+	}
+	salts := make(map[uint16]bool)
+
+	// Message-Authenticator goes first, some NASes only look for it there
 	for _, attr := range r.Attributes {
+		if attr.Type == MessageAuthenticator {
+			attr.Value = make([]byte, messageAuthenticatorLength)
+			buf.Write(attr.Bytes())
+			break
+		}
+	}
 
-		encoded_ok := true
-		switch attr.Type {
-		case UserPassword:
-			// We usually wanna decode the password because if we proxy it
-			// we will need to re-encode with the new secret  anyways
-			password_data := xorPassword(secret, r.Authenticator, attr.Value, false)
-			attr.Length = uint8(len(password_data))
-			attr.Value = password_data[:]
-		case VendorSpecific:
-			vendor_data := encodeVendorSpecificAttr(attr)
-			attr.Length = uint8(len(vendor_data) + 2)
-			attr.Value = vendor_data[:]
-		default:
-			attr.Length = uint8(len(attr.Value))
+	// vendor attributes of the same vendor that follow each other, sent
+	// together so they can be packed
+	var vendorAttrs []RadiusAttribute
+	flushVendorAttrs := func() error {
+
+		if len(vendorAttrs) == 0 {
+			return nil
 		}
 
-		if encoded_ok {
-			buf.Write(attr.Bytes())
+		data, err := d.encodeVendorSpecific(vendorAttrs, r.PackVendorAttributes)
+		if err != nil {
+			return err
 		}
+
+		buf.Write(data)
+		vendorAttrs = nil
+
+		return nil
 	}
 
-	return buf.Bytes()
+	for i := 0; i < len(r.Attributes); i++ {
+
+		attr := r.Attributes[i]
+		if attr.Type == MessageAuthenticator && attr.VendorId == 0 && len(attr.Path) == 0 {
+			continue
+		}
+
+		// TLVs of the same attribute that follow each other are sent in
+		// a single one
+		if len(attr.tlvPath()) > 0 {
+			var leaves []tlvLeaf
+			for ; i < len(r.Attributes); i++ {
+				leaf := r.Attributes[i]
+				if len(leaf.tlvPath()) == 0 || !leaf.sameOuter(attr) {
+					break
+				}
+				leaves = append(leaves, tlvLeaf{leaf.tlvPath(), leaf.Value})
+			}
+			i--
+
+			value, err := encodeTLVs(leaves)
+			if err != nil {
+				return nil, err
+			}
+			attr = attr.outer()
+			attr.Value = value
+		}
+
+		// values are kept in clear text so that proxied packets can hide
+		// them again with their own secret and authenticator
+		switch d.encryption(attr) {
+		case EncryptUserPassword:
+			value, err := hidePassword(secret, authenticator, attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			attr.Value = value
+		case EncryptTunnel:
+			value, err := saltEncrypt(secret, authenticator, newSalt(salts), attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Can't encrypt %v: %v", attr.ID(), err)
+			}
+			attr.Value = value
+		}
+		attr = d.addTag(attr)
+
+		if attr.Type == VendorSpecific && attr.VendorId != 0 {
+			if len(vendorAttrs) > 0 && vendorAttrs[0].VendorId != attr.VendorId {
+				if err := flushVendorAttrs(); err != nil {
+					return nil, err
+				}
+			}
+			vendorAttrs = append(vendorAttrs, attr)
+			continue
+		}
+
+		if err := flushVendorAttrs(); err != nil {
+			return nil, err
+		}
+
+		if isExtended(attr.Type) || isLongExtended(attr.Type) {
+			data, err := encodeExtended(attr)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+			continue
+		}
+
+		if len(attr.Value) > maxAttributeValue {
+			return nil, fmt.Errorf("Value of %v too long.", attr.ID())
+		}
+
+		buf.Write(attr.Bytes())
+	}
+
+	if err := flushVendorAttrs(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 func (r *RadiusPacket) EncodePacket(secret string) ([]byte, error) {
 
+	// the Request Authenticator must be unpredictable (RFC 2865 §3), a zero
+	// one would hide User-Password with nothing but the secret
+	if (r.Code == AccessRequest || r.Code == StatusServer) && r.Authenticator == ZeroedAuthenticator {
+		r.Authenticator = GenerateRandomAuthenticator()
+	}
+
 	// encode all attrs first
-	attrs_data := r.encodeAttrs(secret)
+	attrs_data, err := r.encodeAttrs(secret)
+	if err != nil {
+		return nil, err
+	}
 	attrs_size := len(attrs_data)
 	r.Length = uint16(attrs_size + HEADER_SIZE)
 
 	buf := bytes.NewBuffer([]byte{})
 
-	err := binary.Write(buf, binary.BigEndian, &r.RadiusHeader)
+	err = binary.Write(buf, binary.BigEndian, &r.RadiusHeader)
 	if err != nil {
 		return nil, err
 	}
 
 	err = binary.Write(buf, binary.BigEndian, attrs_data)
+	if err != nil {
+		return nil, err
+	}
+
+	output := buf.Bytes()
+	SignMessageAuthenticator(output, r.Authenticator[:], secret)
+
+	return output, nil
+
+}
+
+// Errors of packets that can't be decoded, returned wrapped in a
+// *PacketError.
+var (
+	ErrTruncatedHeader    = errors.New("Packet shorter than the RADIUS header.")
+	ErrBadLength          = errors.New("Invalid packet length.")
+	ErrBadAttributeLength = errors.New("Invalid attribute length.")
+	ErrTrailingData       = errors.New("Trailing data after the attributes.")
+	ErrBadFragment        = errors.New("Fragmented attribute not terminated.")
+)
+
+// PacketError is returned for malformed packets, Offset is where the
+// problem was found.
+type PacketError struct {
+	Offset int
+	Err    error
+}
 
-	return buf.Bytes(), err
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("offset %v: %v", e.Offset, e.Err)
+}
 
+func (e *PacketError) Unwrap() error {
+	return e.Err
 }
 
 func ParseRADIUSPacket(rawMsg []byte, secret string) (*RadiusPacket, error) {
+	return DefaultDictionary.ParsePacket(rawMsg, secret)
+}
 
-	packet := NewRadiusPacket()
-	reader := bytes.NewReader(rawMsg)
+// ParsePacket is ParseRADIUSPacket with the attributes described by d.
+// Octets after the header Length are padding and are ignored (RFC 2865 §3).
+func (d *Dictionary) ParsePacket(rawMsg []byte, secret string) (*RadiusPacket, error) {
+	return d.parsePacket(rawMsg, nil, secret)
+}
 
-	err := binary.Read(reader, binary.BigEndian, &packet.RadiusHeader)
-	if err != nil {
-		return nil, err
+// ParseResponse is ParsePacket for a reply to a request that had
+// requestAuthenticator, which encrypted attributes such as Tunnel-Password
+// are hidden with.
+func (d *Dictionary) ParseResponse(rawMsg []byte, requestAuthenticator []byte, secret string) (*RadiusPacket, error) {
+	return d.parsePacket(rawMsg, requestAuthenticator, secret)
+}
+
+func (d *Dictionary) parsePacket(rawMsg []byte, requestAuthenticator []byte, secret string) (*RadiusPacket, error) {
+
+	if len(rawMsg) < headerEnd {
+		return nil, &PacketError{len(rawMsg), ErrTruncatedHeader}
 	}
 
-	rawAttributesBytes := rawMsg[headerEnd:]
+	packet := d.NewPacket()
+	packet.Code = rawMsg[0]
+	packet.Identifier = rawMsg[1]
+	packet.Length = binary.BigEndian.Uint16(rawMsg[2:4])
+	copy(packet.Authenticator[:], rawMsg[4:headerEnd])
 
-	rawAttributes := parseAttributes(rawAttributesBytes, packet.Authenticator, secret)
+	length := int(packet.Length)
+	if length < headerEnd || length > maxPacketLength || length > len(rawMsg) {
+		return nil, &PacketError{2, ErrBadLength}
+	}
 
-	for _, attr := range rawAttributes {
-		packet.Attributes = append(packet.Attributes, attr)
+	// values of requests with a hashed authenticator are hidden with a zero
+	// one, it isn't known before they are encoded
+	authenticator := packet.Authenticator
+	if requestAuthenticator != nil {
+		copy(authenticator[:], requestAuthenticator)
+	} else if hasHashedAuthenticator(packet.Code) {
+		authenticator = ZeroedAuthenticator
 	}
 
+	attrs, err := d.parseAttributes(rawMsg[headerEnd:length], authenticator, secret)
+	if err != nil {
+		if perr, ok := err.(*PacketError); ok {
+			perr.Offset += headerEnd
+		}
+		return nil, err
+	}
+	packet.Attributes = attrs
+
 	return packet, nil
 
 }
 
-func parseAttributes(data []byte, requestAuthenticator [16]byte, secret string) []RadiusAttribute {
+func (d *Dictionary) parseAttributes(data []byte, requestAuthenticator [16]byte, secret string) ([]RadiusAttribute, error) {
 
 	var attrs []RadiusAttribute
-	reader := bytes.NewBuffer(data)
 
-	for {
+	// Long Extended Type attribute whose fragments are being gathered
+	var long *RadiusAttribute
+
+	// vendor attribute continued in the next Vendor-Specific
+	var continued *RadiusAttribute
+
+	for offset := 0; offset < len(data); {
+
+		if len(data)-offset < 2 {
+			return nil, &PacketError{offset, ErrTrailingData}
+		}
 
-		var err error
 		attr := RadiusAttribute{}
+		attr.Type = data[offset]
+		attr.Length = data[offset+1]
+
+		if int(attr.Length) < 2 || offset+int(attr.Length) > len(data) {
+			return nil, &PacketError{offset + 1, ErrBadAttributeLength}
+		}
 
-		ok := false
+		value := data[offset+2 : offset+int(attr.Length)]
+		start := offset
+		offset += int(attr.Length)
 
-		attr.Type, err = reader.ReadByte()
-		if err == io.EOF {
-			break
+		if long != nil && !isLongExtended(attr.Type) {
+			return nil, &PacketError{start, ErrBadFragment}
 		}
 
-		attr.Length, err = reader.ReadByte()
-		if err == io.EOF {
-			break
+		if continued != nil && attr.Type != VendorSpecific {
+			return nil, &PacketError{start, ErrBadFragment}
 		}
 
-		switch attr.Type {
-		case uint8(0):
-			log.Printf("attr_type 0?")
-		case uint8(UserPassword):
-			val := reader.Next(int(attr.Length) - 2)
-			attr.Value = xorPassword(secret, requestAuthenticator, val, true)
-			ok = true
-		case uint8(VendorSpecific):
-
-			vsa := VendorSpecificAttribute{}
-			err = binary.Read(reader, binary.BigEndian, &vsa)
+		// the attributes carried by the one read
+		var decoded []RadiusAttribute
+
+		switch {
+		case isExtended(attr.Type):
+			if len(value) < 1 {
+				return nil, &PacketError{start + 1, ErrBadAttributeLength}
+			}
+			attr, err := decodeExtended(attr, value[0], value[1:])
 			if err != nil {
-				log.Fatal(err)
+				return nil, &PacketError{start + 1, ErrBadAttributeLength}
+			}
+			decoded = append(decoded, attr)
+		case isLongExtended(attr.Type):
+			if len(value) < 2 {
+				return nil, &PacketError{start + 1, ErrBadAttributeLength}
+			}
+			if long != nil && (long.Type != attr.Type || long.Path[0] != uint32(value[0])) {
+				return nil, &PacketError{start, ErrBadFragment}
+			}
+			if long == nil {
+				long = &RadiusAttribute{Type: attr.Type, Path: []uint32{uint32(value[0])}}
+			}
+			long.Value = append(long.Value, value[2:]...)
+			if value[1]&longExtendedMore != 0 {
+				break
+			}
+			attr, err := decodeExtended(RadiusAttribute{Type: long.Type}, uint8(long.Path[0]), long.Value)
+			long = nil
+			if err != nil {
+				return nil, &PacketError{start + 1, ErrBadAttributeLength}
+			}
+			decoded = append(decoded, attr)
+		case attr.Type == VendorSpecific:
+			fragments, err := d.decodeVendorSpecific(value)
+			if err != nil {
+				// kept whole when the vendor attributes can't be told
+				// apart, RFC 2865 only recommends their format
+				attr.Value = value
+				decoded = append(decoded, attr)
+				break
+			}
+			for _, fragment := range fragments {
+				if continued != nil && !continued.ID().Equal(fragment.attr.ID()) {
+					return nil, &PacketError{start, ErrBadFragment}
+				}
+				if continued != nil {
+					continued.Value = append(continued.Value, fragment.attr.Value...)
+				} else if fragment.more {
+					// a copy, fragment is reused by the next iteration
+					first := fragment.attr
+					first.Value = append([]byte{}, fragment.attr.Value...)
+					continued = &first
+				}
+				if fragment.more {
+					continue
+				}
+				if continued != nil {
+					fragment.attr = *continued
+					continued = nil
+				}
+				decoded = append(decoded, fragment.attr)
 			}
-
-			attr.VendorId = vsa.VendorId
-			attr.VendorType = vsa.VendorType
-			attr.VendorLength = vsa.VendorLength
-			attr.Value = reader.Next(int(vsa.VendorLength - 2))
-			ok = true
-
-			// log.Printf("praseAttrs: %+v", vsa)
-			// log.Printf("VSA: %+v", vsa)
-			// log.Printf("Value: %v", attr.Value)
-			// log.Printf("Value hex: %x", attr.Value)
 		default:
-			attr.Value = reader.Next(int(attr.Length) - 2)
-			ok = true
+			attr.Value = value
+			decoded = append(decoded, attr)
 		}
 
-		if ok {
-			attrs = append(attrs, attr)
+		for _, attr := range decoded {
+			attr, err := d.stripTag(attr)
+			if err != nil {
+				return nil, &PacketError{start, err}
+			}
+			switch d.encryption(attr) {
+			case EncryptUserPassword:
+				attr.Value, err = unhidePassword(secret, requestAuthenticator, attr.Value)
+			case EncryptTunnel:
+				attr.Value, err = saltDecrypt(secret, requestAuthenticator, attr.Value)
+			}
+			if err != nil {
+				return nil, &PacketError{start, err}
+			}
+			attrs = append(attrs, d.expandTLVs(attr)...)
 		}
+	}
 
+	if long != nil || continued != nil {
+		return nil, &PacketError{len(data), ErrBadFragment}
 	}
 
-	return attrs
+	return attrs, nil
 }
 
 func GenerateRandomAuthenticator() [16]byte {
@@ -406,58 +776,56 @@ func GenerateRandomAuthenticator() [16]byte {
 	return authenticator
 }
 
-func paddAttr(data []byte, size int) []byte {
+// maxPasswordLength is the longest User-Password RFC 2865 §5.2 allows.
+const maxPasswordLength = 128
+
+// hidePassword hides a User-Password as described in RFC 2865 §5.2. The
+// password is padded with NULs to a multiple of 16 octets, and each block is
+// XORed with MD5(secret + previous hidden block), the first one with
+// MD5(secret + Request Authenticator).
+func hidePassword(secret string, authenticator [16]byte, password []byte) ([]byte, error) {
 
-	padded := make([]byte, size)
-	for i, b := range data {
-		padded[i] = b
+	if len(password) > maxPasswordLength {
+		return nil, fmt.Errorf("Password longer than %v octets.", maxPasswordLength)
 	}
-	return padded
 
-}
+	length := (len(password) + 15) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
 
-func xorPassword(secret string, authenticator [16]byte, password []byte, decrypt bool) []byte {
+	hidden := make([]byte, length)
+	copy(hidden, password)
 
-	padding := 16
-	fields := 1
-	for {
-		res := fields * 16
-		if res >= len(password) {
-			padding = res
-			break
+	previous := authenticator[:]
+	for i := 0; i < length; i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			hidden[i+j] ^= hash[j]
 		}
-		fields += 1
+		previous = hidden[i : i+16]
 	}
 
-	paddedPassword := paddAttr(password, padding)
-	second_one_way_hash := authenticator
-	var password_out []byte
-
-	for i := 0; i < padding; i = i + 16 {
+	return hidden, nil
+}
 
-		xored := make([]byte, 16)
-		newHash := md5.New()
-		newHash.Write([]byte(secret))
-		newHash.Write(second_one_way_hash[:])
-		newHashSum := newHash.Sum(nil)
+// unhidePassword reverses hidePassword and strips the NUL padding.
+func unhidePassword(secret string, authenticator [16]byte, hidden []byte) ([]byte, error) {
 
-		for j := 0; j < 16; j++ {
-			xored[j] = paddedPassword[i+j] ^ newHashSum[j]
+	if len(hidden) < 16 || len(hidden) > maxPasswordLength || len(hidden)%16 != 0 {
+		return nil, ErrBadAttributeLength
+	}
 
-			if xored[j] == 0x00 {
-				break
-			}
-		}
+	password := make([]byte, len(hidden))
 
-		if decrypt {
-			copy(second_one_way_hash[:], paddedPassword[i:i+16])
-		} else {
-			copy(second_one_way_hash[:], xored[:])
+	previous := authenticator[:]
+	for i := 0; i < len(hidden); i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			password[i+j] = hidden[i+j] ^ hash[j]
 		}
-
-		password_out = append(password_out, xored...)
-
+		previous = hidden[i : i+16]
 	}
 
-	return password_out
+	return bytes.TrimRight(password, "\x00"), nil
 }
@@ -0,0 +1,201 @@
+package goradius
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Access-Request of RFC 2865 §7.1, secret "xyzzy5461"
+var rfc2865AccessRequest, _ = hex.DecodeString("010000380f403f9473978057bd83d5cb98f4227a" +
+	"01066e656d6f" +
+	"02120dbe708d93d413ce3196e43f782a0aee" +
+	"0406c0a80110" +
+	"050600000003")
+
+func header(code uint8, length int) []byte {
+
+	b := []byte{code, 1, uint8(length >> 8), uint8(length)}
+
+	return append(b, make([]byte, 16)...)
+}
+
+func packet(attrs ...byte) []byte {
+
+	b := header(AccessRequest, headerEnd+len(attrs))
+
+	return append(b, attrs...)
+}
+
+func TestParsePacketErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		raw    []byte
+		err    error
+		offset int
+	}{
+		{"short header", rfc2865AccessRequest[:10], ErrTruncatedHeader, 10},
+		{"length under the header", header(AccessRequest, 19), ErrBadLength, 2},
+		{"length past the data", header(AccessRequest, 21), ErrBadLength, 2},
+		{"length over 4096", append(header(AccessRequest, 4097), make([]byte, 4077)...), ErrBadLength, 2},
+		{"attribute length 1", packet(1, 1, 'a'), ErrBadAttributeLength, 21},
+		{"attribute past the packet", packet(1, 6, 'a', 'b'), ErrBadAttributeLength, 21},
+		{"lone octet", packet(1, 3, 'a', 4), ErrTrailingData, 23},
+		{"unfinished long extended", packet(245, 5, 1, 0x80, 'a'), ErrBadFragment, 25},
+		{"interrupted long extended", packet(245, 5, 1, 0x80, 'a', 1, 3, 'b'), ErrBadFragment, 25},
+		{"short User-Password", packet(2, 6, 1, 2, 3, 4), ErrBadAttributeLength, 20},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseRADIUSPacket(tt.raw, "secret")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: got %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		var perr *PacketError
+		if !errors.As(err, &perr) {
+			t.Errorf("%v: %T isn't a *PacketError", tt.name, err)
+		} else if perr.Offset != tt.offset {
+			t.Errorf("%v: got offset %v, want %v", tt.name, perr.Offset, tt.offset)
+		}
+	}
+}
+
+func TestParsePacketPadding(t *testing.T) {
+
+	raw := append(append([]byte{}, rfc2865AccessRequest...), 0, 0, 0)
+
+	p, err := ParseRADIUSPacket(raw, "xyzzy5461")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Attributes) != 4 {
+		t.Errorf("got %v attributes, want 4", len(p.Attributes))
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+
+	f.Add(rfc2865AccessRequest)
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+
+		p, err := ParseRADIUSPacket(raw, "xyzzy5461")
+		if err != nil {
+			var perr *PacketError
+			if !errors.As(err, &perr) {
+				t.Fatalf("%T isn't a *PacketError: %v", err, err)
+			}
+			return
+		}
+
+		if int(p.Length) > len(raw) {
+			t.Fatalf("Length %v past %v octets", p.Length, len(raw))
+		}
+
+		// whatever was decoded can be printed and encoded again
+		_ = p.String()
+		p.EncodePacket("xyzzy5461")
+	})
+}
+
+func TestHidePasswordRFC2865(t *testing.T) {
+
+	var authenticator [16]byte
+	copy(authenticator[:], rfc2865AccessRequest[4:20])
+	want := rfc2865AccessRequest[28:44]
+
+	hidden, err := hidePassword("xyzzy5461", authenticator, []byte("arctangent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(hidden) != string(want) {
+		t.Errorf("got %x, want %x", hidden, want)
+	}
+
+	p, err := ParseRADIUSPacket(rfc2865AccessRequest, "xyzzy5461")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.GetFirstAttributeAsString("User-Password"); got != "arctangent" {
+		t.Errorf("got User-Password %q", got)
+	}
+
+	// and back to the same octets
+	raw, err := p.EncodePacket("xyzzy5461")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != string(rfc2865AccessRequest) {
+		t.Errorf("encoded %x\nwant    %x", raw, rfc2865AccessRequest)
+	}
+}
+
+func TestHidePasswordLengths(t *testing.T) {
+
+	authenticator := GenerateRandomAuthenticator()
+
+	for _, length := range []int{0, 1, 15, 16, 17, 40, 127, 128} {
+
+		password := make([]byte, length)
+		for i := range password {
+			password[i] = 'a' + uint8(i%26)
+		}
+
+		hidden, err := hidePassword("secret", authenticator, password)
+		if err != nil {
+			t.Fatalf("%v octets: %v", length, err)
+		}
+		if want := (length + 15) / 16 * 16; len(hidden) != want && !(length == 0 && len(hidden) == 16) {
+			t.Errorf("%v octets hidden in %v, want %v", length, len(hidden), want)
+		}
+
+		got, err := unhidePassword("secret", authenticator, hidden)
+		if err != nil {
+			t.Fatalf("%v octets: %v", length, err)
+		}
+		if string(got) != string(password) {
+			t.Errorf("%v octets: got %q, want %q", length, got, password)
+		}
+	}
+
+	if _, err := hidePassword("secret", authenticator, make([]byte, 129)); err == nil {
+		t.Errorf("129 octets hidden")
+	}
+
+	for _, length := range []int{0, 15, 17, 144} {
+		if _, err := unhidePassword("secret", authenticator, make([]byte, length)); !errors.Is(err, ErrBadAttributeLength) {
+			t.Errorf("unhiding %v octets: got %v", length, err)
+		}
+	}
+}
+
+// A request encoded without an authenticator gets a random one, which the
+// password is hidden with.
+func TestEncodeReplacesZeroAuthenticator(t *testing.T) {
+
+	for _, code := range []uint8{AccessRequest, StatusServer} {
+
+		p := NewRadiusPacket()
+		p.Code = code
+		p.AddAttribute("User-Name", []byte("steve"))
+		p.AddAttribute("User-Password", []byte("testing"))
+
+		raw, err := p.EncodePacket("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw[4:20]) == string(ZeroedAuthenticator[:]) {
+			t.Errorf("code %v: authenticator left zero", code)
+		}
+
+		parsed, err := ParseRADIUSPacket(raw, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parsed.GetFirstAttributeAsString("User-Password"); got != "testing" {
+			t.Errorf("code %v: got User-Password %q", code, got)
+		}
+	}
+}
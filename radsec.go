@@ -0,0 +1,227 @@
+package goradius
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// RadSecSecret is the shared secret used for RADIUS over TLS (RFC 6614 §2.3).
+const RadSecSecret = "radsec"
+
+// ErrNoClientCAs is returned by ListenAndServeTLS when TLSConfig doesn't say
+// which CAs sign the certificates of the NASes.
+var ErrNoClientCAs = errors.New("TLSConfig has no ClientCAs to verify client certificates.")
+
+// tlsHandshakeTimeout bounds the TLS handshake of RadSec connections when
+// IdleTimeout doesn't.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ListenAndServeTLS is ListenAndServe for RADIUS over TLS (RFC 6614). The
+// certificate is loaded from certFile and keyFile, client certificates are
+// checked with TLSConfig, which must have ClientCAs when it verifies them.
+func (r *RadiusServer) ListenAndServeTLS(addr, certFile, keyFile string) error {
+
+	if r.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	config, err := r.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Certificates) == 0 || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return r.ServeTLS(tls.NewListener(l, config))
+}
+
+// ServeTLS accepts RadSec connections on l, which must come from
+// tls.NewListener. The NAS is identified by its certificate, see
+// ClientRegistry.AddCertificateName, or else by its address, and the
+// RadSecSecret is used in place of its secret. When Clients is nil any peer
+// that completes the handshake is served, the TLS config alone decides who
+// gets in.
+func (r *RadiusServer) ServeTLS(l net.Listener) error {
+
+	if !r.trackConn(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+
+	for {
+
+		conn, err := l.Accept()
+		if err != nil {
+			if r.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			r.trackConn(l, false)
+			l.Close()
+			return err
+		}
+
+		go r.serveTLSConn(conn)
+
+	}
+}
+
+func (r *RadiusServer) serveTLSConn(conn net.Conn) {
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		log.Printf("Closing connection from %v. Not a TLS connection.", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	// tracked during the handshake so Shutdown can interrupt it
+	if !r.trackConn(tlsConn, true) {
+		conn.Close()
+		return
+	}
+
+	timeout := r.IdleTimeout
+	if timeout <= 0 || timeout > tlsHandshakeTimeout {
+		timeout = tlsHandshakeTimeout
+	}
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("TLS handshake with %v failed: %v", conn.RemoteAddr(), err)
+		r.trackConn(tlsConn, false)
+		conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	client, ok := r.findTLSClient(tlsConn)
+	if !ok {
+		r.stats.drop(DropUnknownClient)
+		r.trackConn(tlsConn, false)
+		conn.Close()
+		return
+	}
+
+	r.serveStream(tlsConn, client)
+}
+
+// findTLSClient maps the peer of a RadSec connection to a client, first by
+// certificate and then by address.
+func (r *RadiusServer) findTLSClient(conn *tls.Conn) (*RadiusClient, bool) {
+
+	var client *RadiusClient
+	state := conn.ConnectionState()
+
+	if r.Clients == nil {
+		client = &RadiusClient{}
+	} else {
+
+		ok := false
+		if len(state.PeerCertificates) > 0 {
+			client, ok = r.Clients.FindByCertificate(state.PeerCertificates[0])
+		}
+
+		if !ok {
+			client, ok = r.Clients.Find(udpAddr(conn.RemoteAddr()).IP)
+		}
+
+		if !ok {
+			return nil, false
+		}
+	}
+
+	radsecClient := *client
+	radsecClient.Secret = RadSecSecret
+
+	return &radsecClient, true
+}
+
+// tlsConfig returns a copy of TLSConfig. Without ClientCAs Go would check
+// client certificates against the system roots, letting in anyone with a
+// publicly issued one, so that is refused.
+func (r *RadiusServer) tlsConfig() (*tls.Config, error) {
+
+	if r.TLSConfig == nil {
+		return nil, ErrNoClientCAs
+	}
+
+	switch r.TLSConfig.ClientAuth {
+	case tls.VerifyClientCertIfGiven, tls.RequireAndVerifyClientCert:
+		if r.TLSConfig.ClientCAs == nil {
+			return nil, ErrNoClientCAs
+		}
+	}
+
+	return r.TLSConfig.Clone(), nil
+}
+
+// certificateNames returns the names a certificate identifies its owner
+// with: the subject common name and the DNS, IP and URI SANs.
+func certificateNames(cert *x509.Certificate) []string {
+
+	var names []string
+
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+
+	names = append(names, cert.DNSNames...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	return names
+}
+
+// AddCertificateName lets a RadSec peer presenting a certificate with name as
+// subject common name or SAN be identified as client.
+func (c *ClientRegistry) AddCertificateName(name string, client *RadiusClient) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.certNames == nil {
+		c.certNames = make(map[string]*RadiusClient)
+	}
+
+	c.certNames[strings.ToLower(name)] = client
+}
+
+// FindByCertificate returns the client registered for one of the names in
+// cert.
+func (c *ClientRegistry) FindByCertificate(cert *x509.Certificate) (*RadiusClient, bool) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, name := range certificateNames(cert) {
+		if client, ok := c.certNames[strings.ToLower(name)]; ok {
+			return client, true
+		}
+	}
+
+	return nil, false
+}
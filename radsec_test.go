@@ -0,0 +1,160 @@
+package goradius
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func startRadSec(t *testing.T, pki *testPKI, clients *ClientRegistry) (*RadiusServer, string) {
+
+	t.Helper()
+
+	r := NewRadiusServer('a')
+	r.IdleTimeout = 0
+	r.Clients = clients
+	r.HandleFunc(AccessRequest, func(ctx context.Context, w ResponseWriter, req *RadiusPacket) error {
+		w.Response().Code = AccessAccept
+		return nil
+	})
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.ServeTLS(tls.NewListener(l, config))
+
+	return r, l.Addr().String()
+}
+
+func TestRadSecExchange(t *testing.T) {
+
+	pki := newTestPKI(t, "radius.example.com", "nas.example.com")
+
+	clients := NewClientRegistry()
+	nas, _ := clients.Add("192.0.2.1", "nas", "unused", "")
+	clients.AddCertificateName("nas.example.com", nas)
+
+	r, addr := startRadSec(t, pki, clients)
+	defer r.Close()
+
+	client := NewTLSClient(&tls.Config{
+		Certificates: []tls.Certificate{pki.client},
+		RootCAs:      pki.pool,
+		ServerName:   "radius.example.com",
+	})
+	client.Timeout = time.Second
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+	req.AddAttribute("User-Name", []byte("steve"))
+
+	res, err := client.Exchange(context.Background(), req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != AccessAccept {
+		t.Errorf("got code %v, want Access-Accept", res.Code)
+	}
+}
+
+func TestRadSecUnknownCertificate(t *testing.T) {
+
+	pki := newTestPKI(t, "radius.example.com", "intruder.example.com")
+
+	r, addr := startRadSec(t, pki, NewClientRegistry())
+	defer r.Close()
+
+	client := NewTLSClient(&tls.Config{
+		Certificates: []tls.Certificate{pki.client},
+		RootCAs:      pki.pool,
+		ServerName:   "radius.example.com",
+	})
+	client.Timeout = 200 * time.Millisecond
+	client.Retries = 1
+
+	req := NewRadiusPacket()
+	req.Code = AccessRequest
+
+	if _, err := client.Exchange(context.Background(), req, addr); err == nil {
+		t.Errorf("unknown NAS got a reply")
+	}
+}
+
+// A peer that never starts the handshake mustn't keep Shutdown waiting,
+// even without an IdleTimeout.
+func TestRadSecShutdownDuringHandshake(t *testing.T) {
+
+	pki := newTestPKI(t, "radius.example.com", "nas.example.com")
+
+	r, addr := startRadSec(t, pki, nil)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// let the server start the handshake
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("connection still open after Shutdown")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("connection still open after Shutdown")
+	}
+}
+
+func TestListenAndServeTLSNeedsClientCAs(t *testing.T) {
+
+	pki := newTestPKI(t, "radius.example.com", "nas.example.com")
+
+	tests := []struct {
+		name   string
+		config *tls.Config
+	}{
+		{"no TLSConfig", nil},
+		{"no ClientCAs", &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientAuth: tls.RequireAndVerifyClientCert}},
+		{"no ClientCAs if given", &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientAuth: tls.VerifyClientCertIfGiven}},
+	}
+
+	for _, tt := range tests {
+		r := NewRadiusServer('a')
+		r.TLSConfig = tt.config
+		if err := r.ListenAndServeTLS("127.0.0.1:0", "", ""); err != ErrNoClientCAs {
+			t.Errorf("%v: got %v, want ErrNoClientCAs", tt.name, err)
+		}
+	}
+
+	r := NewRadiusServer('a')
+	r.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.ListenAndServeTLS("127.0.0.1:0", "", "")
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("got %v, want ErrServerClosed", err)
+	}
+}
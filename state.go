@@ -0,0 +1,105 @@
+package goradius
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+const stateLength = 16
+
+func randomBytes(n int) []byte {
+
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+type stateEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// stateStore keeps the conversations that span several Access-Requests,
+// by the State attribute sent to the NAS in the Access-Challenge (RFC 2865
+// §5.24) and echoed back in the next request. The zero value is ready to use.
+type stateStore struct {
+	lock      sync.Mutex
+	entries   map[string]*stateEntry
+	lastSweep time.Time
+}
+
+// add stores value for ttl under a new random State, which it returns.
+func (s *stateStore) add(value interface{}, ttl time.Duration) []byte {
+
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[string]*stateEntry)
+	}
+
+	if now.Sub(s.lastSweep) > ttl {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	for {
+		state := randomBytes(stateLength)
+		if _, ok := s.entries[string(state)]; !ok {
+			s.entries[string(state)] = &stateEntry{value, now.Add(ttl)}
+			return state
+		}
+	}
+}
+
+// get returns the value stored under state unless it expired.
+func (s *stateStore) get(state []byte) (interface{}, bool) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[string(state)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// take returns the value stored under state like get, and removes it.
+func (s *stateStore) take(state []byte) (interface{}, bool) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[string(state)]
+	if !ok {
+		return nil, false
+	}
+	delete(s.entries, string(state))
+
+	if time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+func (s *stateStore) remove(state []byte) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.entries, string(state))
+}
@@ -0,0 +1,56 @@
+package goradius
+
+import (
+	"sync/atomic"
+)
+
+// DropReason says why the server discarded a packet without answering it.
+type DropReason int
+
+const (
+	DropUnknownClient DropReason = iota
+	DropMalformed
+	DropPolicy
+	DropBadAuthenticator
+	DropMessageAuthenticator
+	DropDuplicate
+	dropReasonCount
+)
+
+var dropReasonNames = map[DropReason]string{
+	DropUnknownClient:        "UnknownClient",
+	DropMalformed:            "Malformed",
+	DropPolicy:               "Policy",
+	DropBadAuthenticator:     "BadAuthenticator",
+	DropMessageAuthenticator: "MessageAuthenticator",
+	DropDuplicate:            "Duplicate",
+}
+
+func (d DropReason) String() string {
+	return dropReasonNames[d]
+}
+
+type serverStats struct {
+	drops    [dropReasonCount]uint64
+	replayed uint64
+}
+
+func (s *serverStats) drop(reason DropReason) {
+	atomic.AddUint64(&s.drops[reason], 1)
+}
+
+// Dropped returns how many packets were dropped for reason.
+func (r *RadiusServer) Dropped(reason DropReason) uint64 {
+
+	if reason < 0 || reason >= dropReasonCount {
+		return 0
+	}
+
+	return atomic.LoadUint64(&r.stats.drops[reason])
+}
+
+// Replayed returns how many retransmitted requests were answered from the
+// duplicate cache.
+func (r *RadiusServer) Replayed() uint64 {
+	return atomic.LoadUint64(&r.stats.replayed)
+}
@@ -0,0 +1,145 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPacketLength is the largest packet RFC 2865 allows
+	maxPacketLength = 4096
+
+	// DefaultIdleTimeout is how long NewRadiusServer keeps a TCP
+	// connection without requests open.
+	DefaultIdleTimeout = 60 * time.Second
+)
+
+// ListenAndServeTCP is ListenAndServe for RADIUS over TCP (RFC 6613).
+func (r *RadiusServer) ListenAndServeTCP(addr, secret string) error {
+
+	r.Secret = secret
+
+	if r.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return r.ServeTCP(l)
+}
+
+// ServeTCP accepts connections on l and handles the requests sent over them
+// with the same handlers used for UDP. It returns ErrServerClosed after
+// Shutdown or Close.
+func (r *RadiusServer) ServeTCP(l net.Listener) error {
+
+	if !r.trackConn(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+
+	for {
+
+		conn, err := l.Accept()
+		if err != nil {
+			if r.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			r.trackConn(l, false)
+			l.Close()
+			return err
+		}
+
+		go r.serveStream(conn, nil)
+
+	}
+}
+
+// serveStream reads the requests sent over a stream connection, framed by
+// the Length in their header, until the peer closes it, it stays idle for
+// longer than IdleTimeout or the server shuts down. client is the client
+// the connection was authenticated as, nil to look it up by address.
+func (r *RadiusServer) serveStream(conn net.Conn, client *RadiusClient) {
+
+	addr := udpAddr(conn.RemoteAddr())
+
+	if client == nil {
+		var ok bool
+		if client, ok = r.findClient(addr); !ok {
+			r.stats.drop(DropUnknownClient)
+			conn.Close()
+			return
+		}
+	}
+
+	if !r.trackConn(conn, true) {
+		conn.Close()
+		return
+	}
+
+	var writeLock sync.Mutex
+	var pending sync.WaitGroup
+
+	write := func(reply []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		_, err := conn.Write(reply)
+		return err
+	}
+
+	defer func() {
+		pending.Wait()
+		r.trackConn(conn, false)
+		conn.Close()
+	}()
+
+	for {
+
+		if r.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(r.IdleTimeout))
+		}
+
+		if r.shuttingDown() {
+			return
+		}
+
+		header := make([]byte, headerEnd)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if length < headerEnd || length > maxPacketLength {
+			log.Printf("Closing connection from %v. Invalid packet length %v.", addr, length)
+			r.stats.drop(DropMalformed)
+			return
+		}
+
+		rawMsg := make([]byte, length)
+		copy(rawMsg, header)
+		if _, err := io.ReadFull(conn, rawMsg[headerEnd:]); err != nil {
+			return
+		}
+
+		if !r.startRequest() {
+			return
+		}
+
+		pending.Add(1)
+		go func() {
+			defer r.inFlight.Done()
+			defer pending.Done()
+			r.handleRequest(rawMsg, addr, client, write)
+		}()
+	}
+}
@@ -0,0 +1,137 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTCP(t *testing.T, idleTimeout time.Duration) (*RadiusServer, net.Conn) {
+
+	t.Helper()
+
+	r := acceptingServer()
+	r.Secret = "secret"
+	r.IdleTimeout = idleTimeout
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.ServeTCP(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	return r, conn
+}
+
+func readStreamPacket(conn net.Conn) ([]byte, error) {
+
+	header := make([]byte, headerEnd)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	packet := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+	copy(packet, header)
+	_, err := io.ReadFull(conn, packet[headerEnd:])
+
+	return packet, err
+}
+
+// waitClosed reports whether the server closed conn before its deadline.
+func waitClosed(conn net.Conn) bool {
+
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+
+	return err != nil
+}
+
+func TestTCPSeveralRequests(t *testing.T) {
+
+	r, conn := startTCP(t, 0)
+	defer r.Close()
+	defer conn.Close()
+
+	// three requests in a single write, split on their Length
+	var stream []byte
+	requests := map[uint8][]byte{}
+	for id := uint8(1); id <= 3; id++ {
+		raw := accessRequest(t, "secret", false)
+		raw[1] = id
+		requests[id] = raw
+		stream = append(stream, raw...)
+	}
+	if _, err := conn.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(requests); i++ {
+		reply, err := readStreamPacket(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, ok := requests[reply[1]]
+		if !ok {
+			t.Fatalf("reply to unknown Identifier %v", reply[1])
+		}
+		if !VerifyResponseAuthenticator(reply, request[4:headerEnd], "secret") {
+			t.Errorf("invalid Response Authenticator for Identifier %v", reply[1])
+		}
+		delete(requests, reply[1])
+	}
+}
+
+func TestTCPInvalidLength(t *testing.T) {
+
+	for _, length := range []uint16{headerEnd - 1, maxPacketLength + 1} {
+
+		r, conn := startTCP(t, 0)
+
+		header := make([]byte, headerEnd)
+		header[0] = AccessRequest
+		binary.BigEndian.PutUint16(header[2:4], length)
+		conn.Write(header)
+
+		if !waitClosed(conn) {
+			t.Errorf("connection open after a Length of %v", length)
+		}
+		if dropped := r.Dropped(DropMalformed); dropped != 1 {
+			t.Errorf("%v malformed packets counted, want 1", dropped)
+		}
+
+		conn.Close()
+		r.Close()
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+
+	r, conn := startTCP(t, 100*time.Millisecond)
+	defer r.Close()
+	defer conn.Close()
+
+	// a request keeps the connection open
+	time.Sleep(60 * time.Millisecond)
+	conn.Write(accessRequest(t, "secret", false))
+	if _, err := readStreamPacket(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if !waitClosed(conn) {
+		t.Fatalf("idle connection still open")
+	}
+	if idle := time.Since(start); idle < 80*time.Millisecond || idle > time.Second {
+		t.Errorf("closed after %v idle, want about 100ms", idle)
+	}
+}
@@ -0,0 +1,188 @@
+package goradius
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrBadSalt is returned, wrapped in a *PacketError, for salt encrypted
+// values whose salt doesn't have its most significant bit set.
+var ErrBadSalt = errors.New("Invalid salt of encrypted attribute.")
+
+// maxTag is the highest tag of RFC 2868 tagged attributes, a larger first
+// octet of a string attribute is part of its value.
+const maxTag = 0x1F
+
+// AddTaggedAttribute adds an attribute that the dictionary flags has_tag,
+// such as the Tunnel-* attributes of RFC 2868. Tag groups the attributes
+// describing the same tunnel, 0 means no tag.
+func (p *RadiusPacket) AddTaggedAttribute(name string, tag uint8, value []byte) error {
+
+	if tag > maxTag {
+		return fmt.Errorf("Invalid tag %v.", tag)
+	}
+
+	id, err := p.dictionary().attributeID(name)
+	if err != nil {
+		return err
+	}
+
+	attr, err := p.dictionary().NewAttribute(id, value)
+	if err != nil {
+		return err
+	}
+	attr.Tag = tag
+
+	p.Attributes = append(p.Attributes, attr)
+
+	return nil
+}
+
+// tagFormat returns whether attr has a tag and if so whether it is an
+// integer, whose tag takes the first of its 4 octets.
+func (d *Dictionary) tagFormat(attr RadiusAttribute) (hasTag, integer bool) {
+
+	def, ok := d.attributeOf(attr)
+	if !ok || !def.HasTag {
+		return false, false
+	}
+
+	return true, def.Type == TypeInteger
+}
+
+// stripTag moves the tag found in the value of attr to its Tag field.
+func (d *Dictionary) stripTag(attr RadiusAttribute) (RadiusAttribute, error) {
+
+	hasTag, integer := d.tagFormat(attr)
+	if !hasTag {
+		return attr, nil
+	}
+
+	switch {
+	case integer:
+		if len(attr.Value) != 4 {
+			return attr, ErrBadAttributeLength
+		}
+		attr.Tag = attr.Value[0]
+		attr.Value = []byte{0, attr.Value[1], attr.Value[2], attr.Value[3]}
+	case d.encryption(attr) == EncryptTunnel:
+		// always there, even when 0
+		if len(attr.Value) == 0 {
+			return attr, ErrBadAttributeLength
+		}
+		attr.Tag = attr.Value[0]
+		attr.Value = attr.Value[1:]
+	case len(attr.Value) > 0 && attr.Value[0] <= maxTag:
+		attr.Tag = attr.Value[0]
+		attr.Value = attr.Value[1:]
+	}
+
+	return attr, nil
+}
+
+// addTag puts the Tag of attr back in its value.
+func (d *Dictionary) addTag(attr RadiusAttribute) RadiusAttribute {
+
+	hasTag, integer := d.tagFormat(attr)
+	if !hasTag {
+		return attr
+	}
+
+	switch {
+	case integer:
+		if len(attr.Value) == 4 {
+			attr.Value = []byte{attr.Tag, attr.Value[1], attr.Value[2], attr.Value[3]}
+		}
+	case d.encryption(attr) == EncryptTunnel:
+		attr.Value = append([]byte{attr.Tag}, attr.Value...)
+	case attr.Tag != 0 || (len(attr.Value) > 0 && attr.Value[0] <= maxTag):
+		// without a tag a value starting like one would lose its first octet
+		attr.Value = append([]byte{attr.Tag}, attr.Value...)
+	}
+
+	return attr
+}
+
+// newSalt returns a random salt with its most significant bit set, as
+// RFC 2868 §3.5 requires, that isn't in used.
+func newSalt(used map[uint16]bool) [2]byte {
+
+	var salt [2]byte
+	for {
+		_, err := rand.Read(salt[:])
+		if err != nil {
+			panic(err)
+		}
+		salt[0] |= 0x80
+
+		n := binary.BigEndian.Uint16(salt[:])
+		if !used[n] {
+			used[n] = true
+			return salt
+		}
+	}
+}
+
+// saltEncrypt hides value as described in RFC 2868 §3.5 for Tunnel-Password,
+// the scheme RFC 2548 uses for the MS-MPPE keys too. A length octet and the
+// value are padded with NULs to a multiple of 16 octets, and each block is
+// XORed with MD5(secret + previous block), the first one with
+// MD5(secret + Request Authenticator + salt). The salt goes first.
+func saltEncrypt(secret string, authenticator [16]byte, salt [2]byte, value []byte) ([]byte, error) {
+
+	if len(value) > 255 {
+		return nil, errors.New("Value longer than 255 octets can't be encrypted.")
+	}
+
+	length := (len(value) + 1 + 15) / 16 * 16
+
+	plain := make([]byte, length)
+	plain[0] = uint8(len(value))
+	copy(plain[1:], value)
+
+	out := append([]byte{}, salt[:]...)
+
+	previous := append(authenticator[:], salt[:]...)
+	for i := 0; i < length; i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			out = append(out, plain[i+j]^hash[j])
+		}
+		previous = out[len(out)-16:]
+	}
+
+	return out, nil
+}
+
+// saltDecrypt reverses saltEncrypt, checking the salt and the length octet.
+func saltDecrypt(secret string, authenticator [16]byte, value []byte) ([]byte, error) {
+
+	if len(value) < 2+16 || (len(value)-2)%16 != 0 {
+		return nil, ErrBadAttributeLength
+	}
+
+	salt, hidden := value[:2], value[2:]
+	if salt[0]&0x80 == 0 {
+		return nil, ErrBadSalt
+	}
+
+	plain := make([]byte, len(hidden))
+
+	previous := append(authenticator[:], salt...)
+	for i := 0; i < len(hidden); i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			plain[i+j] = hidden[i+j] ^ hash[j]
+		}
+		previous = hidden[i : i+16]
+	}
+
+	if int(plain[0]) > len(plain)-1 {
+		return nil, ErrBadAttributeLength
+	}
+
+	return plain[1 : 1+int(plain[0])], nil
+}
@@ -0,0 +1,144 @@
+package goradius
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// The RFCs give no numeric example of salt encryption, these values were
+// worked out separately from the construction of RFC 2868 §3.5, with the
+// secret and Request Authenticator of RFC 2865 §7.1.
+var saltTests = []struct {
+	name  string
+	salt  [2]byte
+	value []byte
+	want  string
+}{
+	{
+		"Tunnel-Password (RFC 2868 §3.5)",
+		[2]byte{0x80, 0x01},
+		[]byte("s3cr37"),
+		"8001c13ce51b1722a8f7c7062f09e6838723",
+	},
+	{
+		"MS-MPPE-Send-Key (RFC 2548 §2.4.2)",
+		[2]byte{0x81, 0x23},
+		[]byte{
+			0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+			16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+		},
+		"812397b9b50a491e330b72d83b47f3376fbf4a15b5f7c0c0aaa6479a3f03b113c28592c3f03cfc86dc5803d90b0ffdad4497",
+	},
+}
+
+func rfc2865Authenticator() [16]byte {
+
+	var authenticator [16]byte
+	copy(authenticator[:], rfc2865AccessRequest[4:20])
+
+	return authenticator
+}
+
+func TestSaltEncrypt(t *testing.T) {
+
+	authenticator := rfc2865Authenticator()
+
+	for _, tt := range saltTests {
+
+		got, err := saltEncrypt("xyzzy5461", authenticator, tt.salt, tt.value)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%v: got %x, want %v", tt.name, got, tt.want)
+		}
+
+		want, _ := hex.DecodeString(tt.want)
+		plain, err := saltDecrypt("xyzzy5461", authenticator, want)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if string(plain) != string(tt.value) {
+			t.Errorf("%v: decrypted %x, want %x", tt.name, plain, tt.value)
+		}
+	}
+}
+
+func TestSaltDecryptErrors(t *testing.T) {
+
+	authenticator := rfc2865Authenticator()
+	valid, _ := hex.DecodeString(saltTests[0].want)
+
+	noSaltBit := append([]byte{}, valid...)
+	noSaltBit[0] &^= 0x80
+
+	// a length octet larger than what follows it
+	tooLong, _ := saltEncrypt("xyzzy5461", authenticator, [2]byte{0x80, 0x01}, make([]byte, 15))
+	tooLong[2] ^= 0x20
+
+	tests := []struct {
+		name  string
+		value []byte
+		err   error
+	}{
+		{"salt bit clear", noSaltBit, ErrBadSalt},
+		{"salt only", valid[:2], ErrBadAttributeLength},
+		{"partial block", valid[:17], ErrBadAttributeLength},
+		{"extra octet", append(append([]byte{}, valid...), 0), ErrBadAttributeLength},
+		{"length past the value", tooLong, ErrBadAttributeLength},
+	}
+
+	for _, tt := range tests {
+		if _, err := saltDecrypt("xyzzy5461", authenticator, tt.value); !errors.Is(err, tt.err) {
+			t.Errorf("%v: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	if _, err := saltEncrypt("xyzzy5461", authenticator, [2]byte{0x80, 0x01}, make([]byte, 256)); err == nil {
+		t.Errorf("256 octets encrypted")
+	}
+}
+
+func TestTaggedAttributesRoundTrip(t *testing.T) {
+
+	request := NewRadiusPacket()
+	request.Code = AccessRequest
+	request.Authenticator = rfc2865Authenticator()
+
+	res := request.Duplicate()
+	res.Code = AccessAccept
+	res.Attributes = nil
+	res.AddTaggedAttribute("Tunnel-Type", 1, []byte{0, 0, 0, 3})
+	res.AddTaggedAttribute("Tunnel-Password", 1, []byte("s3cr37"))
+	res.AddTaggedAttribute("Tunnel-Password", 0, []byte("untagged"))
+	res.AddTaggedAttribute("Tunnel-Private-Group-Id", 2, []byte("vlan10"))
+	// untagged, but starting with what looks like a tag
+	res.AddTaggedAttribute("Tunnel-Private-Group-Id", 0, []byte{0x05, 'x'})
+	res.AddAttributeByID(microsoftAttribute(msMPPESendKey), make([]byte, 32))
+
+	raw, err := EncodeAndSign(res, "xyzzy5461")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := DefaultDictionary.ParseResponse(raw, request.Authenticator[:], "xyzzy5461")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Attributes) != len(res.Attributes) {
+		t.Fatalf("got %v attributes, want %v", len(parsed.Attributes), len(res.Attributes))
+	}
+	for i, attr := range parsed.Attributes {
+		want := res.Attributes[i]
+		if attr.Tag != want.Tag || string(attr.Value) != string(want.Value) {
+			t.Errorf("attribute %v: got tag %v %x, want tag %v %x", i, attr.Tag, attr.Value, want.Tag, want.Value)
+		}
+	}
+
+	// the tagged integer keeps its tag in the first octet on the wire
+	if raw[20] != 64 || raw[22] != 1 {
+		t.Errorf("Tunnel-Type encoded as %x", raw[20:26])
+	}
+}
@@ -0,0 +1,404 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrAttributeNotFound = errors.New("Attribute not found.")
+
+// AttributeTypeError is returned by the typed getters and setters when the
+// dictionary type of an attribute doesn't match the one asked for, or the
+// value doesn't have the size of its type.
+type AttributeTypeError struct {
+	Name string
+	Type DataType
+	Want string
+}
+
+func (e *AttributeTypeError) Error() string {
+	return fmt.Sprintf("Attribute %v is of type %v, not %v.", e.Name, e.Type, e.Want)
+}
+
+// typeSizes are the sizes of the values of the fixed size types.
+var typeSizes = map[DataType]int{
+	TypeByte:      1,
+	TypeShort:     2,
+	TypeInteger:   4,
+	TypeSigned:    4,
+	TypeDate:      4,
+	TypeIPAddr:    net.IPv4len,
+	TypeInteger64: 8,
+	TypeIfId:      8,
+	TypeIPv6Addr:  net.IPv6len,
+}
+
+// typedAttribute returns the dictionary entry of name after checking that
+// its type is one of types. Attributes without a dictionary entry pass, only
+// the size of their value is checked.
+func (p *RadiusPacket) typedAttribute(name string, want string, types ...DataType) (*DictAttribute, error) {
+
+	def, ok := p.dictionary().FindAttribute(name)
+	if !ok {
+		if _, err := p.dictionary().attributeID(name); err == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Unknown attribute %v.", name)
+	}
+
+	for _, t := range types {
+		if def.Type == t {
+			return def, nil
+		}
+	}
+
+	return nil, &AttributeTypeError{name, def.Type, want}
+}
+
+// getTyped returns the value of the first name attribute after checking its
+// type and its size: the one of its dictionary type, or else one of sizes.
+func (p *RadiusPacket) getTyped(name string, want string, sizes []int, types ...DataType) ([]byte, *DictAttribute, error) {
+
+	def, err := p.typedAttribute(name, want, types...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := p.GetAttribute(name)
+	if len(values) == 0 {
+		return nil, nil, ErrAttributeNotFound
+	}
+
+	value := values[0]
+	if def != nil {
+		if size, fixed := typeSizes[def.Type]; fixed {
+			sizes = []int{size}
+		}
+	}
+
+	for _, size := range sizes {
+		if len(value) == size {
+			return value, def, nil
+		}
+	}
+
+	t := TypeOctets
+	if def != nil {
+		t = def.Type
+	}
+
+	return nil, nil, &AttributeTypeError{name, t, fmt.Sprintf("%v (%v bytes)", want, len(value))}
+}
+
+// setTyped replaces the name attributes with value after checking the type.
+func (p *RadiusPacket) setTyped(name string, value []byte, want string, types ...DataType) error {
+
+	if _, err := p.typedAttribute(name, want, types...); err != nil {
+		return err
+	}
+
+	p.RemoveAttribute(name)
+
+	return p.AddAttribute(name, value)
+}
+
+// RemoveAttribute deletes every name attribute from the packet.
+func (p *RadiusPacket) RemoveAttribute(name string) {
+
+	if id, err := p.dictionary().attributeID(name); err == nil {
+		p.RemoveAttributeByID(id)
+	}
+}
+
+func (p *RadiusPacket) GetString(name string) (string, error) {
+
+	if _, err := p.typedAttribute(name, "string", TypeString); err != nil {
+		return "", err
+	}
+
+	values := p.GetAttribute(name)
+	if len(values) == 0 {
+		return "", ErrAttributeNotFound
+	}
+
+	return string(values[0]), nil
+}
+
+func (p *RadiusPacket) SetString(name string, value string) error {
+	return p.setTyped(name, []byte(value), "string", TypeString)
+}
+
+func (p *RadiusPacket) GetOctets(name string) ([]byte, error) {
+
+	if _, err := p.typedAttribute(name, "octets", TypeOctets, TypeString); err != nil {
+		return nil, err
+	}
+
+	values := p.GetAttribute(name)
+	if len(values) == 0 {
+		return nil, ErrAttributeNotFound
+	}
+
+	return values[0], nil
+}
+
+func (p *RadiusPacket) SetOctets(name string, value []byte) error {
+	return p.setTyped(name, value, "octets", TypeOctets, TypeString)
+}
+
+// GetUint32 returns integer attributes, byte and short ones are widened.
+func (p *RadiusPacket) GetUint32(name string) (uint32, error) {
+
+	value, _, err := p.getTyped(name, "integer", []int{1, 2, 4}, TypeInteger, TypeByte, TypeShort)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(decodeUint(value)), nil
+}
+
+// SetUint32 sets integer, byte and short attributes, failing when n doesn't
+// fit the smaller types.
+func (p *RadiusPacket) SetUint32(name string, n uint32) error {
+
+	def, err := p.typedAttribute(name, "integer", TypeInteger, TypeByte, TypeShort)
+	if err != nil {
+		return err
+	}
+
+	size := 4
+	if def != nil {
+		size = typeSizes[def.Type]
+	}
+
+	if size < 4 && n >= 1<<(8*uint(size)) {
+		return &AttributeTypeError{name, def.Type, fmt.Sprintf("integer (%v too big)", n)}
+	}
+
+	return p.setTyped(name, encodeUint(uint64(n), size), "integer", TypeInteger, TypeByte, TypeShort)
+}
+
+func (p *RadiusPacket) GetUint64(name string) (uint64, error) {
+
+	value, _, err := p.getTyped(name, "integer64", []int{1, 2, 4, 8}, TypeInteger64, TypeInteger, TypeByte, TypeShort)
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeUint(value), nil
+}
+
+func (p *RadiusPacket) SetUint64(name string, n uint64) error {
+	return p.setTyped(name, encodeUint(n, 8), "integer64", TypeInteger64)
+}
+
+func (p *RadiusPacket) GetInt32(name string) (int32, error) {
+
+	value, _, err := p.getTyped(name, "signed", []int{4}, TypeSigned)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(binary.BigEndian.Uint32(value)), nil
+}
+
+func (p *RadiusPacket) SetInt32(name string, n int32) error {
+	return p.setTyped(name, encodeUint(uint64(uint32(n)), 4), "signed", TypeSigned)
+}
+
+// GetIP returns ipaddr, ipv6addr and combo-ip attributes.
+func (p *RadiusPacket) GetIP(name string) (net.IP, error) {
+
+	value, _, err := p.getTyped(name, "ipaddr", []int{net.IPv4len, net.IPv6len}, TypeIPAddr, TypeIPv6Addr, TypeComboIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IP(append([]byte{}, value...)), nil
+}
+
+// SetIP sets ipaddr, ipv6addr and combo-ip attributes.
+func (p *RadiusPacket) SetIP(name string, ip net.IP) error {
+
+	def, err := p.typedAttribute(name, "ipaddr", TypeIPAddr, TypeIPv6Addr, TypeComboIP)
+	if err != nil {
+		return err
+	}
+
+	value := []byte(ip.To4())
+	if (def != nil && def.Type == TypeIPv6Addr) || value == nil {
+		value = ip.To16()
+	}
+
+	if value == nil || (def != nil && def.Type == TypeIPAddr && len(value) != net.IPv4len) {
+		t := TypeIPAddr
+		if def != nil {
+			t = def.Type
+		}
+		return &AttributeTypeError{name, t, fmt.Sprintf("address %v", ip)}
+	}
+
+	return p.setTyped(name, value, "ipaddr", TypeIPAddr, TypeIPv6Addr, TypeComboIP)
+}
+
+// GetIPNet returns ipv6prefix and ipv4prefix attributes.
+func (p *RadiusPacket) GetIPNet(name string) (*net.IPNet, error) {
+
+	def, err := p.typedAttribute(name, "ipv6prefix", TypeIPv6Prefix, TypeIPv4Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values := p.GetAttribute(name)
+	if len(values) == 0 {
+		return nil, ErrAttributeNotFound
+	}
+	value := values[0]
+
+	if def != nil && def.Type == TypeIPv4Prefix {
+		if len(value) != 6 || value[1]&0x3f > 32 {
+			return nil, &AttributeTypeError{name, def.Type, "ipv4prefix"}
+		}
+		bits := int(value[1] & 0x3f)
+		return &net.IPNet{IP: net.IP(append([]byte{}, value[2:]...)), Mask: net.CIDRMask(bits, 32)}, nil
+	}
+
+	if len(value) < 2 || len(value) > 18 || value[1] > 128 {
+		return nil, &AttributeTypeError{name, TypeIPv6Prefix, "ipv6prefix"}
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, value[2:])
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(int(value[1]), 128)}, nil
+}
+
+// SetIPNet sets ipv6prefix and ipv4prefix attributes.
+func (p *RadiusPacket) SetIPNet(name string, prefix *net.IPNet) error {
+
+	def, err := p.typedAttribute(name, "ipv6prefix", TypeIPv6Prefix, TypeIPv4Prefix)
+	if err != nil {
+		return err
+	}
+
+	ones, bits := prefix.Mask.Size()
+
+	if def != nil && def.Type == TypeIPv4Prefix {
+		ip := prefix.IP.To4()
+		if ip == nil || bits != 32 {
+			return &AttributeTypeError{name, def.Type, fmt.Sprintf("prefix %v", prefix)}
+		}
+		value := append([]byte{0, byte(ones)}, ip.Mask(prefix.Mask)...)
+		return p.setTyped(name, value, "ipv4prefix", TypeIPv4Prefix)
+	}
+
+	ip := prefix.IP.To16()
+	if ip == nil || bits != 128 {
+		return &AttributeTypeError{name, TypeIPv6Prefix, fmt.Sprintf("prefix %v", prefix)}
+	}
+
+	// only the bytes covered by the prefix length are sent
+	value := append([]byte{0, byte(ones)}, ip.Mask(prefix.Mask)[:(ones+7)/8]...)
+
+	return p.setTyped(name, value, "ipv6prefix", TypeIPv6Prefix)
+}
+
+func (p *RadiusPacket) GetTime(name string) (time.Time, error) {
+
+	value, _, err := p.getTyped(name, "date", []int{4}, TypeDate)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(binary.BigEndian.Uint32(value)), 0), nil
+}
+
+func (p *RadiusPacket) SetTime(name string, t time.Time) error {
+
+	seconds := t.Unix()
+	if seconds < 0 || seconds > 1<<32-1 {
+		return &AttributeTypeError{name, TypeDate, fmt.Sprintf("date (%v out of range)", t)}
+	}
+
+	return p.setTyped(name, encodeUint(uint64(seconds), 4), "date", TypeDate)
+}
+
+// GetInterfaceId returns ifid attributes (RFC 3162 Framed-Interface-Id).
+func (p *RadiusPacket) GetInterfaceId(name string) ([8]byte, error) {
+
+	var id [8]byte
+
+	value, _, err := p.getTyped(name, "ifid", []int{8}, TypeIfId)
+	if err != nil {
+		return id, err
+	}
+
+	copy(id[:], value)
+
+	return id, nil
+}
+
+func (p *RadiusPacket) SetInterfaceId(name string, id [8]byte) error {
+	return p.setTyped(name, id[:], "ifid", TypeIfId)
+}
+
+// GetEnum returns the VALUE name of an enumerated integer attribute, or the
+// number when the dictionary has no name for it.
+func (p *RadiusPacket) GetEnum(name string) (string, error) {
+
+	value, def, err := p.getTyped(name, "integer", []int{1, 2, 4, 8}, TypeInteger, TypeByte, TypeShort, TypeInteger64)
+	if err != nil {
+		return "", err
+	}
+
+	if def == nil {
+		return "", fmt.Errorf("Attribute %v has no values in the dictionary.", name)
+	}
+
+	return formatEnum(def, decodeUint(value)), nil
+}
+
+// SetEnum sets an enumerated attribute by VALUE name, e.g.
+// SetEnum("Service-Type", "Framed-User").
+func (p *RadiusPacket) SetEnum(name string, valueName string) error {
+
+	def, err := p.typedAttribute(name, "integer", TypeInteger, TypeByte, TypeShort, TypeInteger64)
+	if err != nil {
+		return err
+	}
+
+	if def == nil {
+		return fmt.Errorf("Attribute %v has no values in the dictionary.", name)
+	}
+
+	n, ok := def.Value(valueName)
+	if !ok {
+		return fmt.Errorf("Attribute %v has no value %v.", name, valueName)
+	}
+
+	return p.setTyped(name, encodeUint(n, typeSizes[def.Type]), "integer", def.Type)
+}
+
+func decodeUint(value []byte) uint64 {
+
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+
+	return n
+}
+
+func encodeUint(n uint64, size int) []byte {
+
+	value := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		value[i] = byte(n)
+		n >>= 8
+	}
+
+	return value
+}
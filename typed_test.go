@@ -0,0 +1,177 @@
+package goradius
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func typedDictionary(t *testing.T) *Dictionary {
+
+	t.Helper()
+
+	fsys := fstest.MapFS{"dictionary": {Data: []byte(`
+ATTRIBUTE	Test-Byte	241.10	byte
+ATTRIBUTE	Test-Short	241.11	short
+ATTRIBUTE	Test-Integer64	241.12	integer64
+ATTRIBUTE	Test-Signed	241.13	signed
+ATTRIBUTE	Test-IPv4-Prefix	241.14	ipv4prefix
+ATTRIBUTE	Test-Combo-IP	241.15	combo-ip
+`)}}
+
+	d := NewDictionary()
+	if err := d.LoadFS(fsys, "dictionary"); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+
+	p := typedDictionary(t).NewPacket()
+	check := func(name string, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+
+	check("SetString", p.SetString("User-Name", "steve"))
+	s, err := p.GetString("User-Name")
+	check("GetString", err)
+	if s != "steve" {
+		t.Errorf("got User-Name %q", s)
+	}
+
+	check("SetOctets", p.SetOctets("Class", []byte{1, 2, 3}))
+	o, err := p.GetOctets("Class")
+	check("GetOctets", err)
+	if string(o) != "\x01\x02\x03" {
+		t.Errorf("got Class %x", o)
+	}
+
+	for name, n := range map[string]uint32{"Session-Timeout": 3600, "Test-Byte": 200, "Test-Short": 60000} {
+		check("SetUint32 "+name, p.SetUint32(name, n))
+		got, err := p.GetUint32(name)
+		check("GetUint32 "+name, err)
+		if got != n {
+			t.Errorf("got %v %v, want %v", name, got, n)
+		}
+	}
+	if raw := p.GetFirstAttribute("Test-Short"); len(raw) != 2 {
+		t.Errorf("short encoded in %v octets", len(raw))
+	}
+
+	check("SetUint64", p.SetUint64("Test-Integer64", 1<<40))
+	u64, err := p.GetUint64("Test-Integer64")
+	check("GetUint64", err)
+	if u64 != 1<<40 {
+		t.Errorf("got %v", u64)
+	}
+
+	check("SetInt32", p.SetInt32("Test-Signed", -5))
+	i32, err := p.GetInt32("Test-Signed")
+	check("GetInt32", err)
+	if i32 != -5 {
+		t.Errorf("got %v", i32)
+	}
+
+	for name, ip := range map[string]string{
+		"Framed-IP-Address":   "192.0.2.10",
+		"NAS-IPv6-Address":    "2001:db8::1",
+		"Test-Combo-IP":       "2001:db8::2",
+		"Login-IPv6-Host":     "::ffff:192.0.2.1",
+		"NAS-IP-Address":      "198.51.100.1",
+		"Framed-IPv6-Address": "2001:db8::3",
+	} {
+		check("SetIP "+name, p.SetIP(name, net.ParseIP(ip)))
+		got, err := p.GetIP(name)
+		check("GetIP "+name, err)
+		if !got.Equal(net.ParseIP(ip)) {
+			t.Errorf("got %v %v, want %v", name, got, ip)
+		}
+	}
+
+	for name, prefix := range map[string]string{"Framed-IPv6-Prefix": "2001:db8:1::/48", "Test-IPv4-Prefix": "192.0.2.0/24"} {
+		_, want, _ := net.ParseCIDR(prefix)
+		check("SetIPNet "+name, p.SetIPNet(name, want))
+		got, err := p.GetIPNet(name)
+		check("GetIPNet "+name, err)
+		if err == nil && got.String() != want.String() {
+			t.Errorf("got %v %v, want %v", name, got, want)
+		}
+	}
+	if raw := p.GetFirstAttribute("Framed-IPv6-Prefix"); len(raw) != 8 {
+		t.Errorf("/48 encoded in %v octets, want 8", len(raw))
+	}
+
+	when := time.Unix(1700000000, 0)
+	check("SetTime", p.SetTime("Event-Timestamp", when))
+	got, err := p.GetTime("Event-Timestamp")
+	check("GetTime", err)
+	if !got.Equal(when) {
+		t.Errorf("got %v, want %v", got, when)
+	}
+
+	id := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	check("SetInterfaceId", p.SetInterfaceId("Framed-Interface-Id", id))
+	gotId, err := p.GetInterfaceId("Framed-Interface-Id")
+	check("GetInterfaceId", err)
+	if gotId != id {
+		t.Errorf("got %x", gotId)
+	}
+
+	check("SetEnum", p.SetEnum("Service-Type", "Framed-User"))
+	enum, err := p.GetEnum("Service-Type")
+	check("GetEnum", err)
+	if enum != "Framed-User" {
+		t.Errorf("got Service-Type %v", enum)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+
+	p := typedDictionary(t).NewPacket()
+	p.AddAttribute("Session-Timeout", []byte{0, 5})
+	p.AddAttribute("Test-Integer64", []byte{0, 0, 0, 5})
+	p.AddAttribute("Framed-IP-Address", make([]byte, 16))
+	p.AddAttribute("Event-Timestamp", []byte{1, 2, 3})
+	p.AddAttribute("Framed-IPv6-Prefix", make([]byte, 20))
+
+	errs := map[string]error{}
+	_, errs["GetUint32 of a short integer"] = p.GetUint32("Session-Timeout")
+	_, errs["GetUint64 of a short integer64"] = p.GetUint64("Test-Integer64")
+	_, errs["GetIP of 16 octets ipaddr"] = p.GetIP("Framed-IP-Address")
+	_, errs["GetTime of 3 octets"] = p.GetTime("Event-Timestamp")
+	_, errs["GetIPNet of 20 octets"] = p.GetIPNet("Framed-IPv6-Prefix")
+	_, errs["GetUint32 of a string"] = p.GetUint32("User-Name")
+	_, errs["GetString of an integer"] = p.GetString("Session-Timeout")
+	_, errs["GetInt32 of an integer"] = p.GetInt32("Session-Timeout")
+	_, errs["GetIP of a date"] = p.GetIP("Event-Timestamp")
+	errs["SetUint32 too big for byte"] = p.SetUint32("Test-Byte", 256)
+	errs["SetUint32 too big for short"] = p.SetUint32("Test-Short", 1<<16)
+	errs["SetString of an integer"] = p.SetString("Session-Timeout", "5")
+	errs["SetIP of IPv6 in ipaddr"] = p.SetIP("Framed-IP-Address", net.ParseIP("2001:db8::1"))
+	errs["SetTime before 1970"] = p.SetTime("Event-Timestamp", time.Unix(-1, 0))
+	errs["SetIPNet of IPv6 in ipv4prefix"] = p.SetIPNet("Test-IPv4-Prefix", &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)})
+
+	for name, err := range errs {
+		var typeErr *AttributeTypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("%v: got %v, want an AttributeTypeError", name, err)
+		}
+	}
+
+	if _, err := p.GetString("Reply-Message"); err != ErrAttributeNotFound {
+		t.Errorf("got %v for a missing attribute, want ErrAttributeNotFound", err)
+	}
+	if err := p.SetEnum("Service-Type", "No-Such-Service"); err == nil {
+		t.Errorf("unknown VALUE set")
+	}
+	if _, err := p.GetString("No-Such-Attribute"); err == nil {
+		t.Errorf("unknown attribute read")
+	}
+}
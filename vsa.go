@@ -0,0 +1,186 @@
+package goradius
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// flag of the continuation byte of format=1,1,c vendors (WiMAX), set on
+// every fragment but the last one
+const vendorContinuationMore = 0x80
+
+// vendorFormat returns the size of the type and length fields of the
+// attributes of a vendor, 1 and 1 unless its dictionary VENDOR says
+// otherwise.
+func (d *Dictionary) vendorFormat(vendorId uint32) (typeLength, lengthLength int, continuation bool) {
+
+	if vendor, ok := d.FindVendorById(vendorId); ok {
+		return vendor.TypeLength, vendor.LengthLength, vendor.Continuation
+	}
+
+	return 1, 1, false
+}
+
+func vendorHeaderLength(typeLength, lengthLength int, continuation bool) int {
+
+	if continuation {
+		return typeLength + lengthLength + 1
+	}
+
+	return typeLength + lengthLength
+}
+
+func readUint(b []byte) uint32 {
+
+	var n uint32
+	for _, c := range b {
+		n = n<<8 | uint32(c)
+	}
+
+	return n
+}
+
+func appendUint(b []byte, n uint32, size int) []byte {
+
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, uint8(n>>(8*uint(i))))
+	}
+
+	return b
+}
+
+// vendorFragment is a vendor attribute read from a Vendor-Specific, more is
+// set when its value continues in the next one.
+type vendorFragment struct {
+	attr RadiusAttribute
+	more bool
+}
+
+// decodeVendorSpecific splits the value of a Vendor-Specific attribute into
+// the vendor attributes packed in it.
+func (d *Dictionary) decodeVendorSpecific(value []byte) ([]vendorFragment, error) {
+
+	if len(value) < 4 {
+		return nil, errors.New("Vendor-Specific attribute too short.")
+	}
+
+	vendorId := binary.BigEndian.Uint32(value[:4])
+	typeLength, lengthLength, continuation := d.vendorFormat(vendorId)
+	header := vendorHeaderLength(typeLength, lengthLength, continuation)
+
+	var fragments []vendorFragment
+	for data := value[4:]; len(data) > 0; {
+
+		if len(data) < header {
+			return nil, fmt.Errorf("Vendor-Specific attribute of vendor %v truncated.", vendorId)
+		}
+
+		// vendors without a length field have a single attribute per
+		// Vendor-Specific
+		length := len(data)
+		if lengthLength > 0 {
+			length = int(readUint(data[typeLength : typeLength+lengthLength]))
+		}
+
+		if length < header || length > len(data) {
+			return nil, fmt.Errorf("Invalid length in Vendor-Specific attribute of vendor %v.", vendorId)
+		}
+
+		fragment := vendorFragment{}
+		fragment.attr = RadiusAttribute{
+			Type:         VendorSpecific,
+			VendorId:     vendorId,
+			VendorType:   readUint(data[:typeLength]),
+			VendorLength: uint8(length),
+			Value:        data[header:length],
+		}
+
+		if continuation {
+			fragment.more = data[typeLength+lengthLength]&vendorContinuationMore != 0
+		}
+
+		fragments = append(fragments, fragment)
+		data = data[length:]
+	}
+
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("Empty Vendor-Specific attribute of vendor %v.", vendorId)
+	}
+
+	return fragments, nil
+}
+
+// encodeVendorSpecific returns the Vendor-Specific attributes carrying attrs,
+// which belong to the same vendor. With pack set they share as few
+// Vendor-Specific attributes as possible, otherwise each gets its own.
+// Values too long for one attribute are split when the vendor has a
+// continuation flag.
+func (d *Dictionary) encodeVendorSpecific(attrs []RadiusAttribute, pack bool) ([]byte, error) {
+
+	vendorId := attrs[0].VendorId
+	typeLength, lengthLength, continuation := d.vendorFormat(vendorId)
+	header := vendorHeaderLength(typeLength, lengthLength, continuation)
+
+	// the vendor attributes as they go in the Vendor-Specific value
+	var encoded [][]byte
+
+	for _, attr := range attrs {
+
+		if typeLength < 4 && attr.VendorType >= 1<<(8*uint(typeLength)) {
+			return nil, fmt.Errorf("Type of %v too big for its vendor.", attr.ID())
+		}
+
+		value := attr.Value
+		for {
+
+			chunk := value
+			if len(chunk) > maxAttributeValue-4-header {
+				if !continuation {
+					return nil, fmt.Errorf("Value of %v too long.", attr.ID())
+				}
+				chunk = chunk[:maxAttributeValue-4-header]
+			}
+			value = value[len(chunk):]
+
+			b := appendUint(nil, attr.VendorType, typeLength)
+			b = appendUint(b, uint32(header+len(chunk)), lengthLength)
+			if continuation {
+				if len(value) > 0 {
+					b = append(b, vendorContinuationMore)
+				} else {
+					b = append(b, 0)
+				}
+			}
+			encoded = append(encoded, append(b, chunk...))
+
+			if len(value) == 0 {
+				break
+			}
+		}
+	}
+
+	// fragments of a continued value each need their own Vendor-Specific
+	// and without a length field nothing can be packed
+	pack = pack && !continuation && lengthLength > 0
+
+	var out []byte
+	for i := 0; i < len(encoded); {
+
+		value := make([]byte, 4, maxAttributeValue)
+		binary.BigEndian.PutUint32(value, vendorId)
+
+		value = append(value, encoded[i]...)
+		i++
+
+		for pack && i < len(encoded) && len(value)+len(encoded[i]) <= maxAttributeValue {
+			value = append(value, encoded[i]...)
+			i++
+		}
+
+		out = append(out, VendorSpecific, uint8(len(value)+2))
+		out = append(out, value...)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package goradius
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func wimaxDictionary(t *testing.T) *Dictionary {
+
+	t.Helper()
+
+	fsys := fstest.MapFS{"dictionary": {Data: []byte(
+		"VENDOR WiMAX 24757 format=1,1,c\n" +
+			"BEGIN-VENDOR WiMAX\n" +
+			"ATTRIBUTE WiMAX-Test 1 octets\n" +
+			"ATTRIBUTE WiMAX-Other 2 octets\n" +
+			"END-VENDOR WiMAX\n")}}
+
+	d := NewDictionary()
+	if err := d.LoadFS(fsys, "dictionary"); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// wimaxVSA returns a Vendor-Specific carrying WiMAX attributes, each given as
+// type, continuation flag and value.
+func wimaxVSA(attrs ...[]byte) []byte {
+
+	value := []byte{0, 0, 0x60, 0xb5}
+	for _, a := range attrs {
+		value = append(value, a[0], uint8(len(a)+1), a[1])
+		value = append(value, a[2:]...)
+	}
+
+	return append([]byte{VendorSpecific, uint8(len(value) + 2)}, value...)
+}
+
+func wimaxPacket(attrs ...[]byte) []byte {
+
+	raw := []byte{AccessRequest, 1, 0, 0}
+	raw = append(raw, make([]byte, 16)...)
+	for _, a := range attrs {
+		raw = append(raw, a...)
+	}
+	raw[2], raw[3] = uint8(len(raw)>>8), uint8(len(raw))
+
+	return raw
+}
+
+func TestVendorContinuation(t *testing.T) {
+
+	d := wimaxDictionary(t)
+	test := AttributeID{VendorId: 24757, OID: []uint32{1}}
+	other := AttributeID{VendorId: 24757, OID: []uint32{2}}
+
+	first := bytes.Repeat([]byte{'a'}, 200)
+	second := bytes.Repeat([]byte{'b'}, 200)
+
+	tests := []struct {
+		name  string
+		raw   []byte
+		want  string
+		other string
+	}{
+		{
+			"one fragment per Vendor-Specific",
+			wimaxPacket(
+				wimaxVSA(append([]byte{1, 0x80}, first...)),
+				wimaxVSA(append([]byte{1, 0x80}, second...)),
+				wimaxVSA([]byte{1, 0, 'c'})),
+			string(first) + string(second) + "c",
+			"",
+		},
+		{
+			"fragments packed in one Vendor-Specific",
+			wimaxPacket(
+				wimaxVSA([]byte{1, 0x80, 'a', 'a'}, []byte{1, 0x80, 'b', 'b'}, []byte{1, 0, 'c', 'c'}),
+				wimaxVSA([]byte{2, 0, 'x'})),
+			"aabbcc",
+			"x",
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := d.ParsePacket(tt.raw, "secret")
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if values := p.GetAttributeByID(test); len(values) != 1 || string(values[0]) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.name, values, tt.want)
+		}
+		if values := p.GetAttributeByID(other); tt.other != "" && (len(values) != 1 || string(values[0]) != tt.other) {
+			t.Errorf("%v: got WiMAX-Other %q, want %q", tt.name, values, tt.other)
+		}
+	}
+
+	// fragments of different attributes can't be joined
+	raw := wimaxPacket(wimaxVSA([]byte{1, 0x80, 'a'}, []byte{2, 0, 'b'}))
+	if _, err := d.ParsePacket(raw, "secret"); err == nil {
+		t.Errorf("mismatched fragments accepted")
+	}
+}